@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time       time.Time `json:"ts"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	Result     int       `json:"result"`
+}
+
+// auditLog appends one JSON line per state-changing request to a file.
+// When maxSize is set, the file is rotated to path.1, path.2, ... before it
+// would exceed it, keeping at most maxBackups old files.
+type auditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex // protects f, size and rotation
+	f    *os.File
+	size int64
+}
+
+func openAuditLog(path string, maxSizeMB, maxBackups int) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: int64(maxSizeMB) << 20, maxBackups: maxBackups}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts the backups, moves the current file to path.1 and opens a
+// new one. The oldest backup beyond maxBackups is dropped.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	if a.maxBackups <= 0 {
+		os.Remove(a.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", a.path, a.maxBackups))
+		for i := a.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			slog.Error("Failed to rotate audit log", "err", err)
+		}
+	}
+	return a.open()
+}
+
+func (a *auditLog) record(e auditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			slog.Error("Failed to rotate audit log", "err", err)
+			return
+		}
+	}
+	// Each entry is written in a single unbuffered write, so it reaches the
+	// file as soon as the request completes.
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write audit log", "err", err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// auditMiddleware records every POST, PUT, PATCH and DELETE request in a.
+// A nil audit log disables recording.
+func auditMiddleware(a *auditLog) Middleware {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			a.record(auditEntry{
+				Time:       time.Now().UTC(),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				RemoteAddr: r.RemoteAddr,
+				Result:     rec.status,
+			})
+		})
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
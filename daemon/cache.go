@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// controlCache memoizes the control fields of .deb files so that requests
+// do not run dpkg-deb for every package on every call. Entries are keyed by
+// path and discarded when the file's size or modification time changes.
+type controlCache struct {
+	mu      sync.Mutex // protects entries
+	entries map[string]controlEntry
+
+	hits, misses atomic.Int64
+}
+
+type controlEntry struct {
+	size    int64
+	modTime time.Time
+	fields  map[string]string
+}
+
+// fields returns the control fields of the .deb at debPath, reading them
+// with dpkg-deb on a cache miss.
+func (c *controlCache) fields(debPath string) (map[string]string, error) {
+	info, err := os.Stat(debPath)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	e, ok := c.entries[debPath]
+	c.mu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		c.hits.Add(1)
+		return e.fields, nil
+	}
+	c.misses.Add(1)
+
+	out, err := output(exec.Command("dpkg-deb", "-f", debPath))
+	if err != nil {
+		return nil, err
+	}
+	e = controlEntry{size: info.Size(), modTime: info.ModTime(), fields: parseControl(out)}
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[string]controlEntry{}
+	}
+	c.entries[debPath] = e
+	c.mu.Unlock()
+	return e.fields, nil
+}
+
+// forget drops the entry of path, if any.
+func (c *controlCache) forget(path string) {
+	c.mu.Lock()
+	delete(c.entries, path)
+	c.mu.Unlock()
+}
+
+// retain drops the entries whose path is not in keep.
+func (c *controlCache) retain(keep map[string]bool) {
+	c.mu.Lock()
+	for path := range c.entries {
+		if !keep[path] {
+			delete(c.entries, path)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// parseControl parses a control paragraph as printed by dpkg-deb -f.
+// Continuation lines are appended to the previous field.
+func parseControl(data []byte) map[string]string {
+	fields := map[string]string{}
+	var last string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if last != "" {
+				fields[last] += "\n" + line
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = name
+		fields[name] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// scanPackagesOp warms the control cache with every .deb in PoolDir and
+// InstalledDir, and drops entries of files that are gone.
+func (s *Server) scanPackagesOp() {
+	seen := map[string]bool{}
+	pool, err := s.poolFiles()
+	if err != nil {
+		slog.Error("Pool scan failed", "err", err)
+	}
+	installed, err := readFiles(s.cfg.InstalledDir)
+	if err != nil {
+		slog.Error("Installed scan failed", "err", err)
+	}
+	for _, f := range append(pool, installed...) {
+		if !strings.HasSuffix(f.Name, ".deb") {
+			continue
+		}
+		if _, err := s.controls.fields(f.Path); err == nil {
+			seen[f.Path] = true
+		}
+	}
+	s.controls.retain(seen)
+}
+
+// poolScanLoop rescans the package directories until the server stops.
+func (s *Server) poolScanLoop() {
+	s.scanPackagesOp()
+	ticker := time.NewTicker(s.cfg.PoolScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.scanPackagesOp()
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package daemon
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// debMagic is the ar archive signature every .deb file starts with.
+var debMagic = []byte("!<arch>\n")
+
+// isDebContent reports whether head, the first bytes of a file, looks like a
+// Debian package.
+func isDebContent(head []byte) bool {
+	if http.DetectContentType(head) != "application/octet-stream" {
+		return false
+	}
+	return bytes.HasPrefix(head, debMagic)
+}
+
+// depSpec is a single package requirement, e.g. "libssl3 (>= 3.0)".
+type depSpec struct {
+	Name    string
+	Op      string
+	Version string
+}
+
+// Required returns the version constraint in compact form, e.g. ">=3.0".
+func (d depSpec) Required() string {
+	return d.Op + d.Version
+}
+
+// satisfies reports whether version meets the constraint of spec.
+func (d depSpec) satisfies(version string) bool {
+	if version == "" {
+		return false
+	}
+	if d.Op == "" {
+		return true
+	}
+	return compareVersions(version, d.Op, d.Version)
+}
+
+// parseRelations parses a Debian relationship field such as Depends or
+// Conflicts. Each entry holds the alternatives separated by "|".
+func parseRelations(field string) [][]depSpec {
+	var relations [][]depSpec
+	for _, entry := range strings.Split(field, ",") {
+		var alternatives []depSpec
+		for _, alt := range strings.Split(entry, "|") {
+			alt = strings.TrimSpace(alt)
+			if alt == "" {
+				continue
+			}
+			var spec depSpec
+			name, constraint, found := strings.Cut(alt, "(")
+			if found {
+				constraint = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(constraint), ")"))
+				i := strings.IndexFunc(constraint, func(r rune) bool { return !strings.ContainsRune("<>=", r) })
+				if i > 0 {
+					spec.Op = constraint[:i]
+					spec.Version = strings.TrimSpace(constraint[i:])
+				}
+			}
+			// Drop architecture qualifiers such as "libc6:any" or "foo [amd64]".
+			name, _, _ = strings.Cut(strings.TrimSpace(name), " ")
+			name, _, _ = strings.Cut(name, ":")
+			spec.Name = name
+			alternatives = append(alternatives, spec)
+		}
+		if len(alternatives) > 0 {
+			relations = append(relations, alternatives)
+		}
+	}
+	return relations
+}
+
+// getControlField returns the value of a control field of a .deb file.
+func (s *Server) getControlField(debPath, field string) (string, error) {
+	fields, err := s.controls.fields(debPath)
+	if err != nil {
+		return "", err
+	}
+	return fields[field], nil
+}
+
+// installedVersion returns the version of pkgName installed on the system,
+// or "" if it is not installed.
+func (s *Server) installedVersion(pkgName string) string {
+	if !s.isPackageInstalled(pkgName) {
+		return ""
+	}
+	out, err := output(exec.Command("dpkg-query", "-W", "-f=${Version}", pkgName))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// aptCandidate returns the version apt would install for pkgName from its
+// current cache, or "" if the package is unknown or has no candidate.
+func (s *Server) aptCandidate(pkgName string) string {
+	out, err := output(exec.Command("apt-cache", "policy", pkgName))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "Candidate:"); ok {
+			if v = strings.TrimSpace(v); v != "(none)" {
+				return v
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// selfPackage returns the name of the package that owns the running
+// executable, as reported by "dpkg -S".
+func selfPackage() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	out, err := output(exec.Command("dpkg", "-S", exe))
+	if err != nil {
+		return "", fmt.Errorf("dpkg -S %s: %w", exe, err)
+	}
+	// Lines look like "pkg[:arch][, pkg2]: /path"; skip diversion notes.
+	for _, line := range strings.Split(string(out), "\n") {
+		owners, _, ok := strings.Cut(line, ": ")
+		if !ok || strings.HasPrefix(line, "diversion ") {
+			continue
+		}
+		owner, _, _ := strings.Cut(owners, ",")
+		owner, _, _ = strings.Cut(strings.TrimSpace(owner), ":")
+		if owner != "" {
+			return owner, nil
+		}
+	}
+	return "", fmt.Errorf("no package owns %s", exe)
+}
+
+// compareVersions evaluates "a op b" using dpkg's version ordering.
+// op is one of the relation operators: <<, <=, =, >=, >>.
+func compareVersions(a, op, b string) bool {
+	_, err := combinedOutput(exec.Command("dpkg", "--compare-versions", a, op, b))
+	return err == nil
+}
+
+// versionOrder orders the versions a and b like cmp.Compare, using dpkg's
+// version ordering.
+func versionOrder(a, b string) int {
+	switch {
+	case compareVersions(a, "<<", b):
+		return -1
+	case compareVersions(a, ">>", b):
+		return 1
+	}
+	return 0
+}
+
+// packagesStanza returns the entry of f in a Debian Packages index: its
+// control fields followed by Filename, Size, MD5sum and SHA256, as written
+// by dpkg-scanpackages.
+func packagesStanza(f fileEntry) ([]byte, error) {
+	control, err := output(exec.Command("dpkg-deb", "-f", f.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	md5sum, sha256sum := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5sum, sha256sum), file); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.Write(bytes.TrimRight(control, "\n"))
+	fmt.Fprintf(&b, "\nFilename: ./%s\n", f.Name)
+	fmt.Fprintf(&b, "Size: %d\n", f.Info.Size())
+	fmt.Fprintf(&b, "MD5sum: %s\n", hex.EncodeToString(md5sum.Sum(nil)))
+	fmt.Fprintf(&b, "SHA256: %s\n", hex.EncodeToString(sha256sum.Sum(nil)))
+	return b.Bytes(), nil
+}
+
+// Signature states reported by debSignatureStatus.
+const (
+	SignatureValid    = "valid"
+	SignatureInvalid  = "invalid"
+	SignatureUnsigned = "unsigned"
+)
+
+// debSignatureStatus checks the .deb at debPath with dpkg-sig --verify.
+func debSignatureStatus(debPath string) string {
+	out, _ := combinedOutput(exec.Command("dpkg-sig", "--verify", debPath))
+	switch msg := string(out); {
+	case strings.Contains(msg, "GOODSIG"):
+		return SignatureValid
+	case strings.Contains(msg, "NOSIG"):
+		return SignatureUnsigned
+	}
+	return SignatureInvalid
+}
+
+// checkSignature verifies the signature of the .deb at path with dpkg-sig
+// when Config.RequireSignedDebs is set. Failures wrap ErrBadSignature and
+// carry dpkg-sig's output.
+func (s *Server) checkSignature(path string) error {
+	if !s.cfg.RequireSignedDebs {
+		return nil
+	}
+	out, err := combinedOutput(exec.Command("dpkg-sig", "--verify", path))
+	msg := strings.TrimSpace(string(out))
+	if err != nil || !strings.Contains(msg, "GOODSIG") {
+		return fmt.Errorf("%w: %s", ErrBadSignature, msg)
+	}
+	return nil
+}
+
+// debChangelogPattern matches the Debian changelog inside a package's data.
+const debChangelogPattern = "./usr/share/doc/*/changelog.Debian.gz"
+
+// debChangelog extracts and decompresses the Debian changelog embedded in
+// the .deb at debPath. It returns an error wrapping os.ErrNotExist if the
+// package has none.
+func debChangelog(debPath string) ([]byte, error) {
+	cmd := exec.Command("dpkg-deb", "--fsys-tarfile", debPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		slog.Debug("subprocess", "args", cmd.Args, "err", err)
+		return nil, err
+	}
+	// The tar stream is read directly, so log like output does once it ends
+	wait := func() error {
+		err := cmd.Wait()
+		slog.Debug("subprocess", "args", cmd.Args, "stderr", stderr.String(), "err", err)
+		return err
+	}
+	waited := false
+	defer func() {
+		if !waited {
+			stdout.Close()
+			wait()
+		}
+	}()
+
+	tr := tar.NewReader(stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			waited = true
+			if err := wait(); err != nil {
+				return nil, fmt.Errorf("dpkg-deb --fsys-tarfile: %w", err)
+			}
+			return nil, fmt.Errorf("no changelog in %s: %w", path.Base(debPath), os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := path.Match(debChangelogPattern, hdr.Name); !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		zr, err := gzip.NewReader(tr)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	}
+}
+
+// maintainerScripts are the control members of a .deb that dpkg executes.
+var maintainerScripts = []string{"preinst", "postinst", "prerm", "postrm", "config"}
+
+// debMaintainerScripts extracts the control area of the .deb at debPath and
+// returns its maintainer scripts by name. Scripts the package does not ship
+// are omitted.
+func debMaintainerScripts(debPath string) (map[string]string, error) {
+	dir, err := os.MkdirTemp("", "groom-control-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if out, err := combinedOutput(exec.Command("dpkg-deb", "--control", debPath, dir)); err != nil {
+		return nil, fmt.Errorf("dpkg-deb --control: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+
+	scripts := map[string]string{}
+	for _, name := range maintainerScripts {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scripts[name] = string(b)
+	}
+	return scripts, nil
+}
+
+// stubField marks the control data of a stub package built by buildStubDeb.
+const stubField = "X-Groom-Stub"
+
+// buildStubDeb writes to path a package holding only control data for
+// pkgName, used to track a package installed outside of groom. Stubs are
+// marked with stubField and must never be installed.
+func buildStubDeb(path, pkgName, version, arch string) error {
+	dir, err := os.MkdirTemp("", "groom-stub-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "DEBIAN"), 0755); err != nil {
+		return err
+	}
+	control := fmt.Sprintf("Package: %s\nVersion: %s\nArchitecture: %s\nMaintainer: groom\n%s: yes\nDescription: groom tracking stub for %s\n",
+		pkgName, version, arch, stubField, pkgName)
+	if err := os.WriteFile(filepath.Join(dir, "DEBIAN", "control"), []byte(control), 0644); err != nil {
+		return err
+	}
+	if out, err := combinedOutput(exec.Command("dpkg-deb", "--build", dir, path)); err != nil {
+		return fmt.Errorf("dpkg-deb --build: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// isStubDeb reports whether the .deb at debPath was built by buildStubDeb.
+func (s *Server) isStubDeb(debPath string) bool {
+	v, err := s.getControlField(debPath, stubField)
+	return err == nil && v == "yes"
+}
@@ -0,0 +1,128 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// drainState tracks whether the server refuses new staging requests.
+type drainState struct {
+	mu       sync.Mutex // protects the fields below
+	draining bool
+	units    int           // installer units running, see holdForUnit
+	inflight int           // staging requests currently being served
+	idle     chan struct{} // closed when inflight drops to zero, if waited on
+}
+
+// drainRetryAfter is the Retry-After value, in seconds, sent while draining.
+const drainRetryAfter = "30"
+
+// Drain makes the server answer new staging requests (uploads, deletions,
+// installs and removals) with 503 Service Unavailable until Release is
+// called. It waits for staging requests already in flight to finish, or for
+// ctx to be done. Running installs only hold off the requests that race
+// them; Drain is for embedders that need a quiet period, e.g. a backup.
+func (s *Server) Drain(ctx context.Context) error {
+	s.drain.mu.Lock()
+	s.drain.draining = true
+	if s.drain.inflight == 0 {
+		s.drain.mu.Unlock()
+		return nil
+	}
+	if s.drain.idle == nil {
+		s.drain.idle = make(chan struct{})
+	}
+	idle := s.drain.idle
+	s.drain.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release accepts staging requests again after Drain. Installer units
+// still running keep refusing the requests that race them.
+func (s *Server) Release() {
+	s.drain.mu.Lock()
+	s.drain.draining = false
+	s.drain.mu.Unlock()
+}
+
+// holdForUnit refuses the requests that race an installer unit's commit of
+// InstalledDir, see racesInstaller, while the unit runs. Each call is undone
+// by releaseUnit.
+func (s *Server) holdForUnit() {
+	s.drain.mu.Lock()
+	s.drain.units++
+	s.drain.mu.Unlock()
+}
+
+func (s *Server) releaseUnit() {
+	s.drain.mu.Lock()
+	s.drain.units--
+	s.drain.mu.Unlock()
+}
+
+// done ends a staging request, waking Drain when it was the last one.
+func (d *drainState) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.inflight == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// isStagingRequest reports whether r changes the pool or the installed set.
+func isStagingRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, "/pool/") || strings.HasPrefix(r.URL.Path, "/installed/")
+}
+
+// racesInstaller reports whether r changes InstalledDir outside of an
+// install: removals, purges, imports and repairs. Installs themselves are let
+// through, so a second install of the same package still gets 409.
+func racesInstaller(r *http.Request) bool {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/installed/")
+	if !ok {
+		return false
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	switch r.Method {
+	case http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return name == "import" || name == "repair"
+	}
+	return false
+}
+
+// drainMiddleware rejects staging requests while the server is draining.
+func (s *Server) drainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isStagingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.drain.mu.Lock()
+		if s.drain.draining || (s.drain.units > 0 && racesInstaller(r)) {
+			s.drain.mu.Unlock()
+			w.Header().Set("Retry-After", drainRetryAfter)
+			s.writeError(w, newError(http.StatusServiceUnavailable, CodeDraining, "Server is draining, retry later"))
+			return
+		}
+		s.drain.inflight++
+		s.drain.mu.Unlock()
+		defer s.drain.done()
+		next.ServeHTTP(w, r)
+	})
+}
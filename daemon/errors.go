@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Error codes returned in GroomError.Code, for machine-parseable error handling.
+const (
+	CodeBadRequest           = "ErrBadRequest"
+	CodeInvalidFilename      = "ErrInvalidFilename"
+	CodeMethodNotAllowed     = "ErrMethodNotAllowed"
+	CodeNotFound             = "ErrNotFound"
+	CodePoolFileMissing      = "ErrPoolFileMissing"
+	CodeInstalledFileMissing = "ErrInstalledFileMissing"
+	CodeConflict             = "ErrConflict"
+	CodeInstallInProgress    = "ErrInstallInProgress"
+	CodeForbidden            = "ErrForbidden"
+	CodeUnsupportedMediaType = "ErrUnsupportedMediaType"
+	CodeInsufficientStorage  = "ErrInsufficientStorage"
+	CodeNotConfigured        = "ErrNotConfigured"
+	CodeNotImplemented       = "ErrNotImplemented"
+	CodeDraining             = "ErrDraining"
+	CodePreconditionFailed   = "ErrPreconditionFailed"
+	CodeBadSignature         = "ErrBadSignature"
+	CodeRequestTooLarge      = "ErrRequestTooLarge"
+	CodeTooManyRequests      = "ErrTooManyRequests"
+	CodeInternal             = "ErrInternal"
+)
+
+// GroomError is the JSON error body returned by the API.
+type GroomError struct {
+	Status  int // HTTP status code, not serialized
+	Code    string
+	Message string
+	Details map[string]any
+}
+
+func (e *GroomError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func (e *GroomError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code    string         `json:"code"`
+		Message string         `json:"message"`
+		Details map[string]any `json:"details,omitempty"`
+	}{e.Code, e.Message, e.Details})
+}
+
+func newError(status int, code, msg string) *GroomError {
+	return &GroomError{Status: status, Code: code, Message: msg}
+}
+
+// internalError reports an unexpected failure. The cause is kept in Details.
+func internalError(msg string, err error) *GroomError {
+	return &GroomError{
+		Status:  http.StatusInternalServerError,
+		Code:    CodeInternal,
+		Message: msg,
+		Details: map[string]any{"error": err.Error()},
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, e *GroomError) {
+	if e.Status >= http.StatusInternalServerError {
+		slog.Error("❌ "+e.Message, "err", e.Details["error"])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e)
+}
@@ -4,104 +4,687 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // registerHandlers sets up the HTTP routes.
 func (s *Server) registerHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/pool/", s.handlePool)
 	mux.HandleFunc("/installed/", s.handleInstalled)
+	mux.HandleFunc("/release/", s.handleRelease)
+	mux.HandleFunc("/scripts/", s.handleScripts)
+	mux.HandleFunc("/transaction/units", s.handleUnits)
+	mux.HandleFunc("/transaction/simulate", s.handleSimulate)
+	mux.HandleFunc("/transaction/eta", s.handleETA)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/health/deep", s.handleDeepHealth)
+	if s.cfg.EnableWebUI {
+		mux.HandleFunc("/", s.handleWebUI)
+	}
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if len(s.cfg.HealthCheckExtensions) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"healthy"}`))
+		return
+	}
+
+	status := "healthy"
+	checks := make(map[string]string, len(s.cfg.HealthCheckExtensions))
+	for _, c := range s.cfg.HealthCheckExtensions {
+		if err := c.Check(); err != nil {
+			status = "unhealthy"
+			checks[c.Name()] = err.Error()
+		} else {
+			checks[c.Name()] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != "healthy" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
+
+// handleDeepHealth checks that the system tools used for installs are
+// available, so a broken host is detected before the first install.
+func (s *Server) handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	healthy, tools := s.deepHealthOp()
+	status := "healthy"
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"healthy"}`))
+	if !healthy {
+		status = "unhealthy"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "tools": tools})
 }
 
 func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
-	filename := strings.TrimPrefix(r.URL.Path, "/pool/")
+	filename, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/pool/"), "/")
+	if r.Method == http.MethodGet && strings.HasSuffix(action, ".deb") {
+		// GET /pool/amd64/filename.deb -> Download from an architecture subdirectory
+		if filepath.Base(filename) != filename || filepath.Base(action) != action {
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
+			return
+		}
+		s.servePoolFile(w, r, filepath.Join(s.cfg.PoolDir, filename, action))
+		return
+	}
+	if action != "" {
+		s.handlePoolFile(w, r, filename, action)
+		return
+	}
 	switch r.Method {
 	case http.MethodPost:
+		if filename == "verify" {
+			// POST /pool/verify?delete-corrupt=true -> Check every pool file
+			s.extendDeadlines(w)
+			report, err := s.verifyPoolOp(r.URL.Query().Get("delete-corrupt") == "true")
+			if err != nil {
+				s.writeError(w, internalError("Verify pool failed", err))
+				return
+			}
+			writeJSON(w, report)
+			return
+		}
+		if filename == "bulk-delete" {
+			// POST /pool/bulk-delete ["a.deb","b.deb"] -> Best-effort deletion
+			var names []string
+			r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxJSONBodyBytes)
+			if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					s.writeError(w, newError(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body too large"))
+				} else {
+					s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Invalid JSON body, expected an array of filenames"))
+				}
+				return
+			}
+			writeJSON(w, s.bulkDeletePoolOp(names))
+			return
+		}
+		if filename == "export" {
+			// POST /pool/export -> Packages.gz, to use the pool as an apt source
+			s.extendDeadlines(w)
+			packages, err := s.poolExportOp()
+			if err != nil {
+				s.writeError(w, internalError("Export failed", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(packages)
+			return
+		}
 		if filename == "" {
-			http.Error(w, "Filename required", http.StatusBadRequest)
+			s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Filename required"))
 			return
 		}
 		// Basic security check
 		if filepath.Base(filename) != filename {
-			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
 			return
 		}
+		s.extendDeadlines(w)
 		if err := s.uploadPoolOp(filename, r.Body); err != nil {
-			s.fail(w, "Create failed", err)
+			if errors.Is(err, ErrUnsupportedMediaType) {
+				s.writeError(w, newError(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Not a Debian package"))
+			} else if errors.Is(err, ErrBadSignature) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeBadSignature, err.Error()))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Create failed", err))
+			}
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
 	case http.MethodGet:
-		list, err := s.listPoolOp()
-		if err != nil {
-			s.fail(w, "List pool failed", err)
-			return
+		switch filename {
+		case "stats":
+			stats, err := s.poolStatsOp()
+			if err != nil {
+				s.writeError(w, internalError("Pool stats failed", err))
+				return
+			}
+			writeJSON(w, stats)
+		case "duplicates":
+			// GET /pool/duplicates -> Packages with several versions in the pool
+			if r.URL.Query().Get("delete-older") == "true" {
+				// Deletions must be audited and drained like any other
+				s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Use DELETE /pool/duplicates to delete older duplicates"))
+				return
+			}
+			dups, err := s.poolDuplicatesOp(false)
+			if err != nil {
+				s.writeError(w, internalError("Find duplicates failed", err))
+				return
+			}
+			writeJSON(w, dups)
+		case "missing-deps":
+			// GET /pool/missing-deps -> Depends not satisfiable offline, by package
+			s.extendDeadlines(w)
+			missing, err := s.poolMissingDepsOp()
+			if err != nil {
+				s.writeError(w, internalError("Dependency check failed", err))
+				return
+			}
+			writeJSON(w, missing)
+		case "checksums":
+			// GET /pool/checksums -> SHA-256 manifest of the whole pool
+			s.extendDeadlines(w)
+			sums, err := s.poolChecksumsOp()
+			if err != nil {
+				s.writeError(w, internalError("Checksum failed", err))
+				return
+			}
+			writeJSON(w, sums)
+		case "tree":
+			// GET /pool/tree -> Pool files grouped by package, oldest version first
+			tree, err := s.poolTreeOp()
+			if err != nil {
+				s.writeError(w, internalError("Pool tree failed", err))
+				return
+			}
+			writeJSON(w, tree)
+		case "age":
+			// GET /pool/age?max=10 -> Oldest pool files first
+			limit := 0
+			if v := r.URL.Query().Get("max"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Invalid max"))
+					return
+				}
+				limit = n
+			}
+			age, err := s.poolAgeOp(limit)
+			if err != nil {
+				s.writeError(w, internalError("Pool age failed", err))
+				return
+			}
+			writeJSON(w, age)
+		case "Release":
+			// GET /pool/Release -> Minimal unsigned apt Release file
+			s.extendDeadlines(w)
+			release, err := s.poolReleaseOp()
+			if err != nil {
+				s.writeError(w, internalError("Release generation failed", err))
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(release)
+		case "Packages.gz":
+			// GET /pool/Packages.gz -> Same index as POST /pool/export, for apt
+			s.extendDeadlines(w)
+			packages, err := s.poolExportOp()
+			if err != nil {
+				s.writeError(w, internalError("Export failed", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(packages)
+		default:
+			if strings.HasSuffix(filename, ".deb") {
+				// GET /pool/filename.deb -> Download the file
+				if filepath.Base(filename) != filename {
+					s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
+					return
+				}
+				s.servePoolFile(w, r, s.poolPath(filename))
+				return
+			}
+			list, err := s.listPoolOp()
+			if err != nil {
+				s.writeError(w, internalError("List pool failed", err))
+				return
+			}
+			writeJSON(w, list)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(list)
 	case http.MethodDelete:
-		if filename == "" {
-			if err := s.clearPoolOp(); err != nil {
-				s.fail(w, "Clear pool failed", err)
+		if filename == "duplicates" {
+			// DELETE /pool/duplicates -> Keep only the newest of each package
+			dups, err := s.poolDuplicatesOp(true)
+			if err != nil {
+				s.writeError(w, internalError("Delete duplicates failed", err))
 				return
 			}
-		} else {
-			if err := s.deletePoolFileOp(filename); err != nil {
-				s.fail(w, "Delete failed", err)
+			writeJSON(w, dups)
+			return
+		}
+		if filename == "" {
+			res, err := s.clearPoolOp()
+			if err != nil {
+				s.writeError(w, internalError("Clear pool failed", err))
 				return
 			}
+			writeJSON(w, res)
+			return
+		}
+		if err := s.deletePoolFileOp(filename); err != nil {
+			if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Delete failed", err))
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+	}
+}
+
+// handlePoolFile serves the per-file actions under /pool/{filename}/{action}.
+func (s *Server) handlePoolFile(w http.ResponseWriter, r *http.Request, filename, action string) {
+	// Basic security check
+	if filename == "" || filepath.Base(filename) != filename {
+		s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
+		return
+	}
+
+	switch action {
+	case "copy":
+		// POST /pool/filename.deb/copy?dest=other.deb -> Copy within the pool
+		if r.Method != http.MethodPost {
+			s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		dest := r.URL.Query().Get("dest")
+		if dest == "" || filepath.Base(dest) != dest {
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid destination filename"))
+			return
+		}
+		if dest == filename {
+			s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Destination must differ from source"))
+			return
+		}
+		force := r.URL.Query().Get("force") == "true"
+		if err := s.copyPoolFileOp(filename, dest, force); err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+			} else if errors.Is(err, ErrConflict) {
+				s.writeError(w, newError(http.StatusConflict, CodeConflict, "Destination already exists"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Copy failed", err))
+			}
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "checksum":
+		// GET /pool/filename.deb/checksum -> SHA-256 without downloading
+		if r.Method != http.MethodGet {
+			s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		sum, err := s.poolChecksumOp(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Checksum failed", err))
+			}
+			return
+		}
+		writeJSON(w, sum)
+	case "promote":
+		// POST /pool/filename.deb/promote -> Move to the release directory
+		if r.Method != http.MethodPost {
+			s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		if err := s.promotePoolFileOp(filename); err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+			} else if errors.Is(err, ErrNotConfigured) {
+				s.writeError(w, newError(http.StatusNotImplemented, CodeNotConfigured, "Release directory not configured"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Promote failed", err))
+			}
+			return
 		}
 		w.WriteHeader(http.StatusOK)
+	case "apt-deps":
+		// GET /pool/filename.deb/apt-deps -> Check Depends against the apt cache
+		if r.Method != http.MethodGet {
+			s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		deps, err := s.poolAptDepsOp(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Failed to resolve dependencies", err))
+			}
+			return
+		}
+		writeJSON(w, deps)
+	case "conflicts":
+		// GET /pool/filename.deb/conflicts -> Installed packages it conflicts with or replaces
+		if r.Method != http.MethodGet {
+			s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		conflicts, err := s.poolConflictsOp(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Conflict check failed", err))
+			}
+			return
+		}
+		writeJSON(w, map[string][]Conflict{"conflicts": conflicts})
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, newError(http.StatusNotFound, CodeNotFound, "Not found"))
 	}
 }
 
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	list, err := s.listReleaseOp()
+	if err != nil {
+		if errors.Is(err, ErrNotConfigured) {
+			s.writeError(w, newError(http.StatusNotImplemented, CodeNotConfigured, "Release directory not configured"))
+		} else {
+			s.writeError(w, internalError("List release failed", err))
+		}
+		return
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) handleETA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	writeJSON(w, s.etaOp())
+}
+
+// handleSimulate dry-runs installs and removals with apt-get -s.
+func (s *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	var req SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeError(w, newError(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body too large"))
+		} else {
+			s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Invalid JSON body"))
+		}
+		return
+	}
+	for _, name := range req.Install {
+		// Basic security check
+		if name == "" || filepath.Base(name) != name {
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
+			return
+		}
+	}
+	sim, err := s.simulateOp(req)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+		} else if errors.Is(err, ErrInvalidFilename) {
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+		} else {
+			s.writeError(w, internalError("Simulation failed", err))
+		}
+		return
+	}
+	writeJSON(w, sim)
+}
+
+func (s *Server) handleUnits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	units, err := s.listUnitsOp()
+	if err != nil {
+		s.writeError(w, internalError("List units failed", err))
+		return
+	}
+	writeJSON(w, units)
+}
+
+func (s *Server) handleScripts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/scripts/")
+	if name == "" {
+		// GET /scripts/ -> List archived installer scripts
+		list, err := s.listScriptsOp()
+		if err != nil {
+			if errors.Is(err, ErrNotConfigured) {
+				s.writeError(w, newError(http.StatusNotImplemented, CodeNotConfigured, "Install script directory not configured"))
+			} else {
+				s.writeError(w, internalError("List scripts failed", err))
+			}
+			return
+		}
+		writeJSON(w, list)
+		return
+	}
+
+	// GET /scripts/name.sh.done -> Read an archived installer script
+	if filepath.Base(name) != name {
+		s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
+		return
+	}
+	content, err := s.readScriptOp(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.writeError(w, newError(http.StatusNotFound, CodeNotFound, "Script not found"))
+		} else if errors.Is(err, ErrNotConfigured) {
+			s.writeError(w, newError(http.StatusNotImplemented, CodeNotConfigured, "Install script directory not configured"))
+		} else {
+			s.writeError(w, internalError("Read script failed", err))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(content)
+}
+
 func (s *Server) handleInstalled(w http.ResponseWriter, r *http.Request) {
-	arg := strings.TrimPrefix(r.URL.Path, "/installed/")
+	arg, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/installed/"), "/")
+	if arg == "changelog" {
+		s.handleChangelog(w, r, action)
+		return
+	}
+	if action != "" {
+		s.handleInstalledFile(w, r, arg, action)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		if arg == "" {
+		switch arg {
+		case "":
 			list, err := s.listInstalledOp()
 			if err != nil {
-				s.fail(w, "Failed to read installed dir", err)
+				s.writeError(w, internalError("Failed to read installed dir", err))
 				return
 			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(list)
-		} else {
-			http.Error(w, "Not implemented", http.StatusNotImplemented)
+			writeJSON(w, list)
+		case "stats":
+			stats, err := s.installedStatsOp()
+			if err != nil {
+				s.writeError(w, internalError("Installed stats failed", err))
+				return
+			}
+			writeJSON(w, stats)
+		case "outdated":
+			list, err := s.outdatedOp()
+			if err != nil {
+				s.writeError(w, internalError("Outdated check failed", err))
+				return
+			}
+			writeJSON(w, list)
+		case "by-date":
+			// GET /installed/by-date?since=2024-01-01&until=... -> Packages installed in the range
+			var bounds [2]time.Time
+			for i, name := range []string{"since", "until"} {
+				v := r.URL.Query().Get(name)
+				if v == "" {
+					continue
+				}
+				t, err := parseDate(v)
+				if err != nil {
+					s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Invalid "+name+", expected RFC 3339 or YYYY-MM-DD"))
+					return
+				}
+				bounds[i] = t
+			}
+			list, err := s.installedByDateOp(bounds[0], bounds[1])
+			if err != nil {
+				s.writeError(w, internalError("Failed to read installed dir", err))
+				return
+			}
+			writeJSON(w, list)
+		case "verify-signatures":
+			// GET /installed/verify-signatures -> dpkg-sig state of each tracked file
+			states, err := s.installedSignaturesOp()
+			if err != nil {
+				s.writeError(w, internalError("Signature check failed", err))
+				return
+			}
+			writeJSON(w, states)
+		case "export", "Packages":
+			// GET /installed/export -> Packages index, fetched by apt as /installed/Packages
+			index, err := s.installedExportOp()
+			if err != nil {
+				s.writeError(w, internalError("Export failed", err))
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(index)
+		default:
+			if !strings.HasSuffix(arg, ".deb") {
+				s.writeError(w, newError(http.StatusNotImplemented, CodeNotImplemented, "Not implemented"))
+				return
+			}
+			// GET /installed/filename.deb -> Download the tracked file, never a stub
+			path, err := s.installedPath(arg)
+			if err == nil && s.isStubDeb(path) {
+				err = os.ErrNotExist
+			}
+			if err == nil {
+				err = s.serveDeb(w, r, path)
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					s.writeError(w, newError(http.StatusNotFound, CodeInstalledFileMissing, "File not found in installed"))
+				} else if errors.Is(err, ErrInvalidFilename) {
+					s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+				} else {
+					s.writeError(w, internalError("Download failed", err))
+				}
+			}
 		}
 	case http.MethodPost:
+		s.extendDeadlines(w)
+		if arg == "import" {
+			// POST /installed/import -> Track a package installed outside of groom
+			var req ImportRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					s.writeError(w, newError(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body too large"))
+				} else {
+					s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Invalid JSON body"))
+				}
+				return
+			}
+			filename, err := s.importInstalledOp(req)
+			if err != nil {
+				if errors.Is(err, ErrInvalidFilename) {
+					s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+				} else if errors.Is(err, os.ErrNotExist) {
+					s.writeError(w, newError(http.StatusNotFound, CodeNotFound, err.Error()))
+				} else if errors.Is(err, ErrConflict) {
+					s.writeError(w, newError(http.StatusConflict, CodeConflict, err.Error()))
+				} else {
+					s.writeError(w, internalError("Import failed", err))
+				}
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, map[string]string{"filename": filename})
+			return
+		}
+		if arg == "repair" {
+			// POST /installed/repair -> Reinstall packages dpkg lost track of
+			repairs, err := s.repairInstalledOp()
+			if err != nil {
+				s.writeError(w, internalError("Repair failed", err))
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			writeJSON(w, repairs)
+			return
+		}
 		// POST /installed/filename.deb -> Install from pool
 		if arg == "" {
-			http.Error(w, "Filename required", http.StatusBadRequest)
+			s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Filename required"))
 			return
 		}
 		// Basic security check
 		if filepath.Base(arg) != arg {
-			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
 			return
 		}
 
 		unitName, err := s.scheduleInstallOp(arg)
 		if err != nil {
 			if os.IsNotExist(err) {
-				http.Error(w, "File not found in pool", http.StatusNotFound)
+				s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else if errors.Is(err, ErrConflict) {
+				s.writeError(w, newError(http.StatusConflict, CodeInstallInProgress, "An installation of this package is already running"))
+			} else if errors.Is(err, ErrForbidden) {
+				s.writeError(w, newError(http.StatusForbidden, CodeForbidden, "Self-update of groom agent is disabled"))
+			} else if errors.Is(err, ErrInsufficientStorage) {
+				s.writeError(w, newError(http.StatusInsufficientStorage, CodeInsufficientStorage, err.Error()))
+			} else if errors.Is(err, ErrPreconditionFailed) {
+				s.writeError(w, newError(http.StatusPreconditionFailed, CodePreconditionFailed, err.Error()))
+			} else if errors.Is(err, ErrBadSignature) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeBadSignature, err.Error()))
 			} else {
-				log.Printf("❌ Failed to launch installer: %v", err)
-				http.Error(w, fmt.Sprintf("Failed to schedule installation: %v", err), http.StatusInternalServerError)
+				s.writeError(w, internalError("Failed to schedule installation", err))
 			}
 			return
 		}
@@ -110,23 +693,39 @@ func (s *Server) handleInstalled(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Installation scheduled. Monitor journalctl -u %s", unitName)
 
 	case http.MethodDelete:
+		s.extendDeadlines(w)
 		if arg == "" {
+			if s.cfg.DisablePurge {
+				s.writeError(w, newError(http.StatusForbidden, CodeForbidden,
+					"Purge is disabled, remove packages individually with DELETE /installed/{filename}"))
+				return
+			}
 			count, err := s.purgeInstalledOp()
 			if err != nil {
-				s.fail(w, "Purge failed", err)
+				s.writeError(w, internalError("Purge failed", err))
 				return
 			}
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, "Purged %d packages", count)
+		} else if arg == "orphans" {
+			// DELETE /installed/orphans -> Forget packages dpkg no longer has
+			count, err := s.removeOrphansOp()
+			if err != nil {
+				s.writeError(w, internalError("Orphan cleanup failed", err))
+				return
+			}
+			writeJSON(w, map[string]int{"removed": count})
 		} else {
 			pkgName, err := s.removePackageOp(arg)
 			if err != nil {
 				if os.IsNotExist(err) {
-					http.Error(w, "File not found in installed", http.StatusNotFound)
+					s.writeError(w, newError(http.StatusNotFound, CodeInstalledFileMissing, "File not found in installed"))
+				} else if errors.Is(err, ErrInvalidFilename) {
+					s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
 				} else if errors.Is(err, ErrForbidden) {
-					http.Error(w, "Cannot remove groom agent itself via API", http.StatusForbidden)
+					s.writeError(w, newError(http.StatusForbidden, CodeForbidden, "Cannot remove groom agent itself via API"))
 				} else {
-					s.fail(w, fmt.Sprintf("Remove failed: %v", err), err)
+					s.writeError(w, internalError("Remove failed", err))
 				}
 				return
 			}
@@ -134,11 +733,142 @@ func (s *Server) handleInstalled(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "Removed %s", pkgName)
 		}
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+	}
+}
+
+// handleChangelog serves GET /installed/changelog/{packageName}.
+func (s *Server) handleChangelog(w http.ResponseWriter, r *http.Request, pkgName string) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if pkgName == "" || strings.Contains(pkgName, "/") {
+		s.writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "Invalid package name"))
+		return
+	}
+	changelog, err := s.installedChangelogOp(pkgName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.writeError(w, newError(http.StatusNotFound, CodeNotFound, "Changelog not found"))
+		} else {
+			s.writeError(w, internalError("Changelog extraction failed", err))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(changelog)
+}
+
+// handleInstalledFile serves the per-file actions under /installed/{filename}/{action}.
+func (s *Server) handleInstalledFile(w http.ResponseWriter, r *http.Request, filename, action string) {
+	// Basic security check
+	if filename == "" || filepath.Base(filename) != filename {
+		s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, "Invalid filename"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+
+	switch action {
+	case "depends":
+		deps, err := s.installedDependsOp(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodeInstalledFileMissing, "File not found in installed"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Failed to resolve dependencies", err))
+			}
+			return
+		}
+		writeJSON(w, deps)
+	case "scripts":
+		// GET /installed/filename.deb/scripts -> Maintainer scripts by name
+		scripts, err := s.installedScriptsOp(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				s.writeError(w, newError(http.StatusNotFound, CodeInstalledFileMissing, "File not found in installed"))
+			} else if errors.Is(err, ErrInvalidFilename) {
+				s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+			} else {
+				s.writeError(w, internalError("Failed to read maintainer scripts", err))
+			}
+			return
+		}
+		writeJSON(w, scripts)
+	default:
+		s.writeError(w, newError(http.StatusNotFound, CodeNotFound, "Not found"))
+	}
+}
+
+// parseDate parses an RFC 3339 timestamp or a plain YYYY-MM-DD date (UTC).
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
 	}
+	return time.Parse(time.DateOnly, v)
 }
 
-func (s *Server) fail(w http.ResponseWriter, msg string, err error) {
-	log.Printf("❌ %s: %v", msg, err)
-	http.Error(w, msg, http.StatusInternalServerError)
+// extendDeadlines lets a request that streams a package, scans the pool or
+// waits for apt, dpkg or an installer unit read and write for
+// OperationTimeout instead of WriteTimeout.
+func (s *Server) extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(s.cfg.OperationTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		slog.Warn("Cannot extend read deadline", "err", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		slog.Warn("Cannot extend write deadline", "err", err)
+	}
+}
+
+// servePoolFile sends the pool file at path, which may be in an architecture
+// subdirectory, with Range and conditional request support.
+func (s *Server) servePoolFile(w http.ResponseWriter, r *http.Request, path string) {
+	rel, err := filepath.Rel(s.cfg.PoolDir, path)
+	if err == nil {
+		path, err = secureJoin(s.cfg.PoolDir, rel)
+	}
+	if err == nil {
+		err = s.serveDeb(w, r, path)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.writeError(w, newError(http.StatusNotFound, CodePoolFileMissing, "File not found in pool"))
+		} else if errors.Is(err, ErrInvalidFilename) {
+			s.writeError(w, newError(http.StatusBadRequest, CodeInvalidFilename, err.Error()))
+		} else {
+			s.writeError(w, internalError("Download failed", err))
+		}
+	}
+}
+
+// serveDeb sends the .deb file at path.
+func (s *Server) serveDeb(w http.ResponseWriter, r *http.Request, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return os.ErrNotExist
+	}
+	s.extendDeadlines(w)
+	w.Header().Set("Content-Type", "application/vnd.debian.binary-package")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
 }
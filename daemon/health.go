@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// HealthChecker is an extension point for GET /health.
+type HealthChecker interface {
+	// Name identifies the check in the health report.
+	Name() string
+	// Check returns nil when healthy.
+	Check() error
+}
+
+// DiskSpaceChecker returns a HealthChecker that fails when the filesystem
+// holding path has less than minFreeBytes available.
+func DiskSpaceChecker(path string, minFreeBytes int64) HealthChecker {
+	return diskSpaceChecker{path: path, minFree: minFreeBytes}
+}
+
+type diskSpaceChecker struct {
+	path    string
+	minFree int64
+}
+
+func (c diskSpaceChecker) Name() string { return "disk_space:" + c.path }
+
+func (c diskSpaceChecker) Check() error { return checkDiskSpace(c.path, c.minFree) }
+
+// deepHealthTools are the system tools groom shells out to.
+var deepHealthTools = []string{"dpkg-deb", "apt-get", "systemd-run"}
+
+// ToolCheck is the result of running a system tool with --version.
+type ToolCheck struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output"`
+}
+
+// deepHealthOp runs every tool in deepHealthTools with --version and
+// reports whether all of them succeeded.
+func (s *Server) deepHealthOp() (bool, map[string]ToolCheck) {
+	healthy := true
+	checks := make(map[string]ToolCheck, len(deepHealthTools))
+	for _, tool := range deepHealthTools {
+		out, err := combinedOutput(exec.Command(tool, "--version"))
+		check := ToolCheck{OK: err == nil, Output: strings.TrimSpace(string(out))}
+		if err != nil {
+			healthy = false
+			if check.Output == "" {
+				check.Output = err.Error()
+			}
+		}
+		checks[tool] = check
+	}
+	return healthy, checks
+}
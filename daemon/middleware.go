@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Middleware wraps an http.Handler with additional behavior.
+type Middleware func(http.Handler) http.Handler
+
+// chain applies middlewares to h, the first one being the outermost.
+func chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// CORSMiddleware adds CORS headers for requests coming from allowedOrigins
+// and answers preflight requests. "*" allows any origin. With no allowed
+// origins, requests pass through untouched.
+func CORSMiddleware(allowedOrigins []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(allowedOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && (slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, origin)) {
+				h := w.Header()
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+				h.Set("Access-Control-Allow-Methods", strings.Join([]string{
+					http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions,
+				}, ", "))
+				h.Set("Access-Control-Allow-Headers", "Content-Type")
+
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// maxBodyMiddleware limits request bodies to limit bytes, except under
+// /pool/ where package uploads are streamed to disk. Requests announcing a
+// larger body are refused with 413 Request Entity Too Large.
+func (s *Server) maxBodyMiddleware(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/pool/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				s.writeError(w, newError(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body too large"))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// concurrencyMiddleware serves at most limit requests at a time and refuses
+// the others with 503 Service Unavailable. A limit of zero disables it.
+func (s *Server) concurrencyMiddleware(limit int) Middleware {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		tokens := make(chan struct{}, limit)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case tokens <- struct{}{}:
+				defer func() { <-tokens }()
+				next.ServeHTTP(w, r)
+			default:
+				s.writeError(w, newError(http.StatusServiceUnavailable, CodeTooManyRequests, "Too many concurrent requests"))
+			}
+		})
+	}
+}
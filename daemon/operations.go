@@ -1,19 +1,63 @@
 package daemon
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/brutella/dnssd"
 )
 
-var ErrForbidden = fmt.Errorf("forbidden")
+var (
+	ErrForbidden = fmt.Errorf("forbidden")
+	ErrConflict  = fmt.Errorf("conflict")
+
+	ErrUnsupportedMediaType = fmt.Errorf("unsupported media type")
+	ErrInsufficientStorage  = fmt.Errorf("insufficient storage")
+	ErrNotConfigured        = fmt.Errorf("not configured")
+	ErrPreconditionFailed   = fmt.Errorf("precondition failed")
+	ErrBadSignature         = fmt.Errorf("bad signature")
+	ErrInvalidFilename      = fmt.Errorf("invalid filename")
+)
+
+// Run time limits of Config.PreflightCmd and Config.PostCommitCmd.
+const (
+	preflightTimeout  = 30 * time.Second
+	postCommitTimeout = 10 * time.Second
+)
+
+// unitPollInterval is how often watchUnit checks an installer unit's state.
+const unitPollInterval = 2 * time.Second
+
+// Lifecycle events that run the commands of Config.Hooks.
+const (
+	HookPreInstall           = "pre-install"
+	HookPostInstall          = "post-install"
+	HookPreRemove            = "pre-remove"
+	HookPostRemove           = "post-remove"
+	HookTransactionCommitted = "transaction-committed"
+	HookTransactionFailed    = "transaction-failed"
+)
+
+// hookTimeout bounds the run time of each Config.Hooks command.
+const hookTimeout = 30 * time.Second
 
 // Template for the installer script executed via systemd-run
 const installerScriptTemplate = `#!/bin/bash
@@ -23,10 +67,31 @@ POOL_FILE="%s"
 TARGET_FILE="%s"
 CURRENT_FILE="%s"
 BACKUP_FILE="%s"
+STAGING_DIR="%s"
+PACKAGE_NAME="%s"
+PACKAGE_VERSION="%s"
+INSTALLED_MODE="%04o"
+HISTORY_FILE="%s"
+POST_COMMIT_CMD=(%s)
+HOOK_PRE_INSTALL=(%s)
+HOOK_POST_INSTALL=(%s)
+HOOK_COMMITTED=(%s)
+HOOK_FAILED=(%s)
 
 log() { echo "[Groom-Installer] $1"; }
 
+# run_hook EVENT CMD...: run a lifecycle hook, failures are only logged
+run_hook() {
+  local event="$1"
+  shift
+  [ $# -gt 0 ] || return 0
+  log "Running $event hook"
+  GROOM_EVENT="$event" GROOM_PACKAGE="$PACKAGE_NAME" GROOM_VERSION="$PACKAGE_VERSION" \
+    timeout 30 "$@" || log "$event hook failed."
+}
+
 log "Starting installation of $(basename "$POOL_FILE")"
+run_hook pre-install "${HOOK_PRE_INSTALL[@]}"
 
 # Backup existing installed file if it exists
 if [ -n "$CURRENT_FILE" ] && [ -f "$CURRENT_FILE" ]; then
@@ -35,14 +100,45 @@ if [ -n "$CURRENT_FILE" ] && [ -f "$CURRENT_FILE" ]; then
 fi
 
 # Attempt installation
-log "Running apt-get install..."
-# We use apt-get install to handle dependencies resolution if needed
-if apt-get install -y "$POOL_FILE"; then
+install() {
+  log "Running apt-get install..."
+  # We use apt-get install to handle dependencies resolution if needed
+  if apt-get install -y -- "$POOL_FILE"; then
+    return 0
+  fi
+  # Many failures come from a broken dpkg state: try to fix it and retry once
+  log "Install failed, running apt-get -f install to repair dpkg state..."
+  if apt-get -f install -y; then
+    log "Fix-install step succeeded, retrying installation..."
+    apt-get install -y -- "$POOL_FILE"
+    return $?
+  fi
+  log "Fix-install step failed."
+  return 1
+}
+
+if install; then
   log "Installation successful."
+  run_hook post-install "${HOOK_POST_INSTALL[@]}"
   
   # Commit: Move pool file to installed location (Source of Truth)
   log "Committing: Moving pool file to installed cache"
-  mv "$POOL_FILE" "$TARGET_FILE"
+  if [ -n "$STAGING_DIR" ]; then
+    # Copy next to the target first, so the final rename is atomic
+    STAGED_FILE="$STAGING_DIR/$(basename "$TARGET_FILE")"
+    if cp "$POOL_FILE" "$STAGED_FILE" && mv "$STAGED_FILE" "$TARGET_FILE"; then
+      rm -f "$POOL_FILE"
+    else
+      log "Staging failed, moving pool file directly"
+      rm -f "$STAGED_FILE"
+      mv "$POOL_FILE" "$TARGET_FILE"
+    fi
+  else
+    mv "$POOL_FILE" "$TARGET_FILE"
+  fi
+  chmod "$INSTALLED_MODE" "$TARGET_FILE"
+  rm -f "$POOL_FILE.sha256"
+  printf '%%s\t%%s\n' "$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)" "$(basename "$TARGET_FILE")" >> "$HISTORY_FILE"
   
   # Cleanup backup
   if [ -n "$BACKUP_FILE" ] && [ -f "$BACKUP_FILE" ]; then
@@ -51,14 +147,26 @@ if apt-get install -y "$POOL_FILE"; then
   fi
   
   log "SUCCESS"
+  run_hook transaction-committed "${HOOK_COMMITTED[@]}"
+
+  if [ ${#POST_COMMIT_CMD[@]} -gt 0 ]; then
+    log "Running post-commit command"
+    GROOM_PACKAGES_INSTALLED="$PACKAGE_NAME" GROOM_PACKAGES_REMOVED="" \
+      timeout 10 "${POST_COMMIT_CMD[@]}" || log "Post-commit command failed."
+  fi
   exit 0
 else
   log "Installation failed."
+  run_hook transaction-failed "${HOOK_FAILED[@]}"
   
   # Rollback
-  if [ -n "$BACKUP_FILE" ] && [ -f "$BACKUP_FILE" ]; then
+  if [ -n "$BACKUP_FILE" ] && [ -f "$BACKUP_FILE" ] && [ "$(dpkg-deb -f "$BACKUP_FILE" X-Groom-Stub)" = "yes" ]; then
+    # Imported stubs carry no files: installing one would wipe the package
+    log "Previous version was imported without its package file, cannot re-install it."
+    mv "$BACKUP_FILE" "$CURRENT_FILE"
+  elif [ -n "$BACKUP_FILE" ] && [ -f "$BACKUP_FILE" ]; then
     log "Rolling back: Re-installing previous version"
-    if apt-get install -y "$BACKUP_FILE"; then
+    if apt-get install -y -- "$BACKUP_FILE"; then
       log "Rollback installation successful."
       log "Restoring backup file to active position"
       mv "$BACKUP_FILE" "$CURRENT_FILE"
@@ -75,16 +183,26 @@ fi
 `
 
 func (s *Server) startAdvertisingOp(port int) (func(), error) {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	hostname := s.cfg.Hostname
+	if hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+		hostname = h
+	}
+	// Operator fields never override the computed ones
+	text := maps.Clone(s.cfg.AdvertiseTXT)
+	if text == nil {
+		text = map[string]string{}
 	}
+	text["version"] = s.cfg.Version
 	cfg := dnssd.Config{
 		Name:   hostname,
 		Type:   "_groom._tcp",
 		Domain: "local",
 		Port:   port,
-		Text:   map[string]string{"version": s.cfg.Version},
+		Text:   text,
 	}
 	service, err := dnssd.NewService(cfg)
 	if err != nil {
@@ -98,198 +216,2149 @@ func (s *Server) startAdvertisingOp(port int) (func(), error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to add service to responder: %w", err)
 	}
-	go responder.Respond(context.Background())
-	return func() { responder.Remove(handle) }, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	go responder.Respond(ctx)
+	return func() {
+		responder.Remove(handle)
+		cancel()
+	}, nil
 }
 
-func (s *Server) listPoolOp() ([]string, error) {
-	files, err := os.ReadDir(s.cfg.PoolDir)
+// fileEntry is a regular file found in one of groom's directories.
+type fileEntry struct {
+	Name string
+	Path string
+	Info os.FileInfo
+}
+
+// readFiles lists the regular files in dir, skipping hidden files (such as
+// in-progress uploads) and checksum sidecars.
+func readFiles(dir string) ([]fileEntry, error) {
+	files, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	var list []string
+	var list []fileEntry
 	for _, f := range files {
-		if !f.IsDir() {
-			list = append(list, f.Name())
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || isChecksumSidecar(f.Name()) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
 		}
+		list = append(list, fileEntry{Name: f.Name(), Path: filepath.Join(dir, f.Name()), Info: info})
 	}
 	return list, nil
 }
 
-func (s *Server) uploadPoolOp(filename string, content io.Reader) error {
+// poolFiles lists the pool files, including those stored in architecture
+// subdirectories. Pool files are identified by their base name.
+func (s *Server) poolFiles() ([]fileEntry, error) {
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return nil, err
+	}
+	list, err := readFiles(s.cfg.PoolDir)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(s.cfg.PoolDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		sub, err := readFiles(filepath.Join(s.cfg.PoolDir, d.Name()))
+		if err != nil {
+			continue
+		}
+		list = append(list, sub...)
+	}
+	return list, nil
+}
+
+// poolPath returns the path of a pool file, looking into architecture
+// subdirectories when it is not at the top level. If the file does not exist,
+// the top-level path is returned.
+func (s *Server) poolPath(filename string) string {
 	path := filepath.Join(s.cfg.PoolDir, filename)
-	f, err := os.Create(path)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	// Not filepath.Glob: filename comes from the request and may hold wildcards
+	dirs, _ := os.ReadDir(s.cfg.PoolDir)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if candidate := filepath.Join(s.cfg.PoolDir, d.Name(), filename); fileExists(candidate) {
+			return candidate
+		}
+	}
+	return path
+}
+
+// securePoolPath is poolPath resolved through secureJoin, for reading or
+// moving the file, so a symlink in PoolDir cannot lead outside of it.
+func (s *Server) securePoolPath(filename string) (string, error) {
+	rel, err := filepath.Rel(s.cfg.PoolDir, s.poolPath(filename))
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer f.Close()
-	_, err = io.Copy(f, content)
-	return err
+	return secureJoin(s.cfg.PoolDir, rel)
 }
 
-func (s *Server) clearPoolOp() error {
-	if err := os.RemoveAll(s.cfg.PoolDir); err != nil {
-		return err
+// migratePoolOp moves the pool files stored at the top level of PoolDir into
+// their architecture subdirectory. It returns the number of files moved.
+func (s *Server) migratePoolOp() (int, error) {
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return 0, err
+	}
+	files, err := readFiles(s.cfg.PoolDir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, f := range files {
+		arch, err := s.getControlField(f.Path, "Architecture")
+		if err != nil || arch == "" || filepath.Base(arch) != arch {
+			slog.Warn("Skipping unreadable file", "file", f.Name)
+			continue
+		}
+		if err := s.moveToArchDir(f.Path, arch, f.Name); err != nil {
+			slog.Error("Failed to migrate pool file", "file", f.Name, "err", err)
+			continue
+		}
+		count++
 	}
-	return os.MkdirAll(s.cfg.PoolDir, 0755)
+	return count, nil
 }
 
-func (s *Server) deletePoolFileOp(filename string) error {
-	return os.Remove(filepath.Join(s.cfg.PoolDir, filename))
+// moveToArchDir renames path to PoolDir/arch/filename, dropping any cached
+// checksum of the source.
+func (s *Server) moveToArchDir(path, arch, filename string) error {
+	dir := filepath.Join(s.cfg.PoolDir, arch)
+	if err := os.MkdirAll(dir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return err
+	}
+	dest, err := secureJoin(s.cfg.PoolDir, filepath.Join(arch, filename))
+	if err != nil {
+		return err
+	}
+	os.Remove(path + checksumSuffix)
+	os.Remove(dest + checksumSuffix)
+	return os.Rename(path, dest)
 }
 
-func (s *Server) listInstalledOp() ([]string, error) {
-	files, err := os.ReadDir(s.cfg.InstalledDir)
+func (s *Server) listPoolOp() ([]string, error) {
+	files, err := s.poolFiles()
 	if err != nil {
 		return nil, err
 	}
 	var list []string
 	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(f.Name(), ".deb") {
-			list = append(list, f.Name())
-		}
+		list = append(list, f.Name)
 	}
 	return list, nil
 }
 
-func (s *Server) scheduleInstallOp(poolFilename string) (string, error) {
-	sourcePath := filepath.Join(s.cfg.PoolDir, poolFilename)
-	if _, err := os.Stat(sourcePath); err != nil {
-		return "", err
-	}
+// PoolStats summarizes the storage used by the pool.
+type PoolStats struct {
+	TotalFiles           int   `json:"total_files"`
+	TotalBytes           int64 `json:"total_bytes"`
+	OldestFileAgeSeconds int64 `json:"oldest_file_age_seconds"`
+	NewestFileAgeSeconds int64 `json:"newest_file_age_seconds"`
+	MetadataCacheHits    int64 `json:"metadata_cache_hits"`
+	MetadataCacheMisses  int64 `json:"metadata_cache_misses"`
+	// PoolBytes is the space the pool files take on disk, allocated blocks
+	// included. FSFreeBytes and FSTotalBytes describe the filesystem holding
+	// PoolDir.
+	PoolBytes    int64 `json:"pool_bytes"`
+	FSFreeBytes  int64 `json:"fs_free_bytes"`
+	FSTotalBytes int64 `json:"fs_total_bytes"`
+}
 
-	// Identify Package Name to find potential conflicts/upgrades
-	pkgName, err := s.getPackageName(sourcePath)
+func (s *Server) poolStatsOp() (PoolStats, error) {
+	stats := PoolStats{
+		MetadataCacheHits:   s.controls.hits.Load(),
+		MetadataCacheMisses: s.controls.misses.Load(),
+	}
+	files, err := s.poolFiles()
 	if err != nil {
-		return "", fmt.Errorf("invalid deb file: %w", err)
+		return stats, err
 	}
 
-	// Paths configuration
-	targetDeb := filepath.Join(s.cfg.InstalledDir, poolFilename)
-	currentDeb := s.findInstalledPackage(pkgName)
-	backupDeb := ""
-	if currentDeb != "" {
-		backupDeb = currentDeb + ".previous"
+	var oldest, newest time.Time
+	for _, f := range files {
+		info := f.Info
+		stats.TotalFiles++
+		stats.TotalBytes += info.Size()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			stats.PoolBytes += st.Blocks * 512
+		} else {
+			stats.PoolBytes += info.Size()
+		}
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+		if newest.IsZero() || info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	if stats.TotalFiles > 0 {
+		stats.OldestFileAgeSeconds = int64(time.Since(oldest).Seconds())
+		stats.NewestFileAgeSeconds = int64(time.Since(newest).Seconds())
 	}
 
-	// Generate the ephemeral installer script
-	scriptContent := fmt.Sprintf(installerScriptTemplate, sourcePath, targetDeb, currentDeb, backupDeb)
-	scriptPath := filepath.Join(os.TempDir(), fmt.Sprintf("groom_install_%s.sh", pkgName))
-
-	if err := os.WriteFile(scriptPath, []byte(scriptContent), 0755); err != nil {
-		return "", fmt.Errorf("failed to create installer script: %w", err)
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.cfg.PoolDir, &st); err != nil {
+		return stats, err
 	}
+	stats.FSFreeBytes = int64(st.Bavail) * int64(st.Bsize)
+	stats.FSTotalBytes = int64(st.Blocks) * int64(st.Bsize)
+	return stats, nil
+}
 
-	// Construct a unique unit name for systemd-run
-	unitName := fmt.Sprintf("groom-install-%s", pkgName)
+// FileAge is a pool file and the time since it was last modified.
+type FileAge struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
 
-	log.Printf("🚀 Launching detached installation for %s (unit: %s)...", pkgName, unitName)
+// PoolAge lists pool files, oldest first.
+type PoolAge struct {
+	Files      []FileAge `json:"files"`
+	TotalBytes int64     `json:"total_bytes"`
+}
 
-	// Launch via systemd-run
-	cmd := exec.Command("systemd-run",
-		"--unit="+unitName,
-		"--description=Groom Service Installer Worker for "+pkgName,
-		"--service-type=oneshot",
-		// Allow the script to live even if groom dies (which happens during self-update)
-		"--collect",
-		scriptPath,
-	)
+// poolAgeOp returns the limit oldest pool files, or all of them if limit is 0.
+// TotalBytes covers the whole pool.
+func (s *Server) poolAgeOp(limit int) (PoolAge, error) {
+	res := PoolAge{Files: []FileAge{}}
+	files, err := s.poolFiles()
+	if err != nil {
+		return res, err
+	}
+	slices.SortFunc(files, func(a, b fileEntry) int {
+		return a.Info.ModTime().Compare(b.Info.ModTime())
+	})
+	for _, f := range files {
+		res.TotalBytes += f.Info.Size()
+		if limit > 0 && len(res.Files) >= limit {
+			continue
+		}
+		res.Files = append(res.Files, FileAge{
+			Name:       f.Name,
+			Size:       f.Info.Size(),
+			AgeSeconds: int64(time.Since(f.Info.ModTime()).Seconds()),
+		})
+	}
+	return res, nil
+}
 
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("%s", string(output))
+func (s *Server) uploadPoolOp(filename string, content io.Reader) error {
+	if p := s.cfg.PoolFileNamePattern; p != nil && !p.MatchString(filename) {
+		return fmt.Errorf("%w: %s does not match %s", ErrInvalidFilename, filename, p)
 	}
 
-	return unitName, nil
+	// Sniff the content before touching the disk
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	if !isDebContent(head) {
+		return ErrUnsupportedMediaType
+	}
+
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return err
+	}
+	content = io.MultiReader(bytes.NewReader(head), content)
+	if s.cfg.ArchSubdirs {
+		err = s.uploadToArchDir(filename, content)
+	} else {
+		err = s.uploadToPoolDir(filename, content)
+	}
+	if err != nil {
+		return err
+	}
+	s.enforcePackageQuota(filename)
+	return nil
 }
 
-func (s *Server) removePackageOp(filename string) (string, error) {
-	installedPath := filepath.Join(s.cfg.InstalledDir, filename)
-	if _, err := os.Stat(installedPath); err != nil {
-		return "", err
+func (s *Server) uploadToPoolDir(filename string, content io.Reader) error {
+	path, err := secureJoin(s.cfg.PoolDir, filename)
+	if err != nil {
+		return err
+	}
+	// Write to a hidden temporary file and rename it into place, so an
+	// aborted or rejected upload never truncates or removes the existing file.
+	f, err := os.CreateTemp(s.cfg.PoolDir, ".upload-*")
+	if err != nil {
+		return err
 	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
 
-	pkgName, err := s.getPackageName(installedPath)
+	_, err = io.Copy(f, content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, s.cfg.PoolDirMode)
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to read package info: %w", err)
+		return err
+	}
+	if err := s.checkSignature(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
 	}
+	// Any cached metadata belongs to the previous content
+	s.controls.forget(path)
+	os.Remove(path + checksumSuffix)
+	return nil
+}
 
-	// Prevent suicide: do not allow removing the agent itself
-	if pkgName == s.cfg.SelfPackageName {
-		return "", ErrForbidden
+// uploadToArchDir writes content to a hidden temporary file, reads its
+// Architecture field and moves it to the matching pool subdirectory.
+func (s *Server) uploadToArchDir(filename string, content io.Reader) error {
+	f, err := os.CreateTemp(s.cfg.PoolDir, ".upload-*")
+	if err != nil {
+		return err
 	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
 
-	log.Printf("🗑️ Removing %s...", pkgName)
-	cmd := exec.Command("apt-get", "remove", "-y", pkgName)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("remove failed: %s: %w", string(out), err)
+	_, err = io.Copy(f, content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, s.cfg.PoolDirMode)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.checkSignature(tmp); err != nil {
+		return err
 	}
 
-	// Remove record from installed
-	os.Remove(installedPath)
-	return pkgName, nil
+	arch, err := s.getControlField(tmp, "Architecture")
+	if err != nil {
+		return fmt.Errorf("invalid deb file: %w", err)
+	}
+	if arch == "" || filepath.Base(arch) != arch {
+		return fmt.Errorf("invalid deb file: bad architecture %q", arch)
+	}
+	return s.moveToArchDir(tmp, arch, filename)
 }
 
-func (s *Server) purgeInstalledOp() (int, error) {
-	files, err := os.ReadDir(s.cfg.InstalledDir)
+// enforcePackageQuota deletes the oldest pool versions of the package just
+// uploaded as filename while there are more than its PoolSizeQuotaPerPackage.
+// The uploaded file itself is always kept.
+func (s *Server) enforcePackageQuota(filename string) {
+	if len(s.cfg.PoolSizeQuotaPerPackage) == 0 {
+		return
+	}
+	path, err := s.securePoolPath(filename)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil
+		return
+	}
+	pkgName, err := s.getPackageName(path)
+	if err != nil {
+		return
+	}
+	quota := s.cfg.PoolSizeQuotaPerPackage[pkgName]
+	if quota <= 0 {
+		return
+	}
+	files, err := s.poolFiles()
+	if err != nil {
+		slog.Error("Pool quota check failed", "err", err)
+		return
+	}
+	var versions []debInfo
+	for _, d := range s.scanDebs(files) {
+		if d.Package == pkgName {
+			versions = append(versions, d)
 		}
-		return 0, err
+	}
+	slices.SortFunc(versions, func(a, b debInfo) int { return versionOrder(a.Version, b.Version) })
+	kept := int64(len(versions))
+	for _, d := range versions {
+		if kept <= quota {
+			break
+		}
+		if d.File == filename {
+			continue
+		}
+		slog.Info("🗑️ Evicting pool file, quota exceeded", "package", d.Package, "version", d.Version, "file", d.File, "quota", quota)
+		if err := s.deletePoolFileOp(d.File); err != nil {
+			slog.Error("Failed to evict pool file", "file", d.File, "err", err)
+			continue
+		}
+		kept--
+	}
+}
+
+// ClearResult summarizes a pool clean-up.
+type ClearResult struct {
+	Deleted    int      `json:"deleted"`
+	BytesFreed int64    `json:"bytes_freed"`
+	Failed     []string `json:"failed,omitempty"`
+}
+
+// clearPoolOp deletes every file in the pool. Files that cannot be deleted
+// are reported in the result rather than aborting the operation.
+func (s *Server) clearPoolOp() (ClearResult, error) {
+	var res ClearResult
+	files, err := s.poolFiles()
+	if err != nil {
+		return res, err
 	}
 
-	count := 0
 	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(f.Name(), ".deb") {
-			fullPath := filepath.Join(s.cfg.InstalledDir, f.Name())
-			pkgName, err := s.getPackageName(fullPath)
-			if err != nil {
-				log.Printf("Skipping unreadable file %s", f.Name())
-				continue
-			}
+		if err := os.Remove(f.Path); err != nil {
+			slog.Error("Failed to delete pool file", "file", f.Name, "err", err)
+			res.Failed = append(res.Failed, f.Name)
+			continue
+		}
+		os.Remove(f.Path + checksumSuffix)
+		res.Deleted++
+		res.BytesFreed += f.Info.Size()
+	}
 
-			// Protect Groom
-			if pkgName == s.cfg.SelfPackageName {
-				continue
+	// Drop architecture subdirectories left empty
+	if dirs, err := os.ReadDir(s.cfg.PoolDir); err == nil {
+		for _, d := range dirs {
+			if d.IsDir() {
+				os.Remove(filepath.Join(s.cfg.PoolDir, d.Name()))
 			}
+		}
+	}
+	return res, nil
+}
 
-			log.Printf("🔥 Purging %s...", pkgName)
-			// Purge to remove config files too
-			cmd := exec.Command("apt-get", "purge", "-y", pkgName)
-			if out, err := cmd.CombinedOutput(); err != nil {
-				log.Printf("Failed to purge package %s: %s", pkgName, string(out))
-				continue
+func (s *Server) deletePoolFileOp(filename string) error {
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s.cfg.PoolDir, s.poolPath(filename))
+	if err != nil {
+		return err
+	}
+	// Only the directory is resolved: a symlink itself is removed, not its target
+	dir, err := secureJoin(s.cfg.PoolDir, filepath.Dir(rel))
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(rel))
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	s.controls.forget(path)
+	os.Remove(path + checksumSuffix)
+	return nil
+}
+
+// BulkDeleteResult reports which pool files were deleted and why the
+// others were not.
+type BulkDeleteResult struct {
+	Deleted []string          `json:"deleted"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// bulkDeletePoolOp deletes each of filenames from the pool, best effort.
+func (s *Server) bulkDeletePoolOp(filenames []string) BulkDeleteResult {
+	res := BulkDeleteResult{Deleted: []string{}, Failed: map[string]string{}}
+	for _, name := range filenames {
+		// Basic security check
+		if name == "" || filepath.Base(name) != name {
+			res.Failed[name] = "invalid filename"
+			continue
+		}
+		if err := s.deletePoolFileOp(name); err != nil {
+			if os.IsNotExist(err) {
+				res.Failed[name] = "not found"
+			} else {
+				res.Failed[name] = err.Error()
 			}
-			os.Remove(fullPath)
-			count++
+			continue
 		}
+		res.Deleted = append(res.Deleted, name)
 	}
-	return count, nil
+	return res
 }
 
-func (s *Server) getPackageName(debPath string) (string, error) {
-	// dpkg-deb -f file Package
-	out, err := exec.Command("dpkg-deb", "-f", debPath, "Package").Output()
+// copyPoolFileOp publishes the pool file src under the name dest.
+// The copy goes through the upload path, so it is validated like one.
+// Unless force is set, an existing dest is reported as ErrConflict.
+func (s *Server) copyPoolFileOp(src, dest string, force bool) error {
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return err
+	}
+	srcPath, err := s.securePoolPath(src)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if _, err := os.Stat(srcPath); err != nil {
+		return err
+	}
+	destPath := s.poolPath(dest)
+	if _, err := os.Stat(destPath); err == nil {
+		if !force {
+			return ErrConflict
+		}
+		if err := os.Remove(destPath); err != nil {
+			return err
+		}
+		os.Remove(destPath + checksumSuffix)
 	}
-	return strings.TrimSpace(string(out)), nil
-}
 
-func (s *Server) findInstalledPackage(pkgName string) string {
-	files, err := os.ReadDir(s.cfg.InstalledDir)
+	// Copy rather than link: uploads rewrite pool files in place, which would
+	// silently change every name sharing the inode.
+	in, err := os.Open(srcPath)
 	if err != nil {
-		return ""
+		return err
 	}
+	defer in.Close()
+	return s.uploadPoolOp(dest, in)
+}
 
-	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(f.Name(), ".deb") {
-			path := filepath.Join(s.cfg.InstalledDir, f.Name())
-			name, err := s.getPackageName(path)
-			if err == nil && name == pkgName {
-				return path
-			}
-		}
+// promotePoolFileOp moves a pool file into ReleaseDir.
+func (s *Server) promotePoolFileOp(filename string) error {
+	if s.cfg.ReleaseDir == "" {
+		return ErrNotConfigured
 	}
-	return ""
+	if err := ensureDir(s.cfg.ReleaseDir, 0755); err != nil {
+		return err
+	}
+	src, err := s.securePoolPath(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	dst, err := secureJoin(s.cfg.ReleaseDir, filename)
+	if err != nil {
+		return err
+	}
+	if err := moveFile(src, dst); err != nil {
+		return err
+	}
+	os.Remove(src + checksumSuffix)
+	slog.Info("🏷️ Promoted to release", "file", filename)
+	return nil
+}
+
+func (s *Server) listReleaseOp() ([]string, error) {
+	if s.cfg.ReleaseDir == "" {
+		return nil, ErrNotConfigured
+	}
+	if err := ensureDir(s.cfg.ReleaseDir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := readFiles(s.cfg.ReleaseDir)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, f := range files {
+		list = append(list, f.Name)
+	}
+	return list, nil
+}
+
+// sourcePath locates a file to install, in the pool first and then in
+// ReleaseDir. If neither has it, the pool path is returned. Both are resolved
+// through secureJoin.
+func (s *Server) sourcePath(filename string) (string, error) {
+	path, err := s.securePoolPath(filename)
+	if err != nil {
+		return "", err
+	}
+	if fileExists(path) || s.cfg.ReleaseDir == "" {
+		return path, nil
+	}
+	release, err := secureJoin(s.cfg.ReleaseDir, filename)
+	if os.IsNotExist(err) {
+		return path, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if fileExists(release) {
+		return release, nil
+	}
+	return path, nil
+}
+
+// Checksum describes the SHA-256 digest of a pool file.
+type Checksum struct {
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// checksumSuffix is appended to a pool file name to form its checksum sidecar.
+const checksumSuffix = ".sha256"
+
+func isChecksumSidecar(name string) bool {
+	return strings.HasSuffix(name, checksumSuffix)
+}
+
+// poolChecksumOp returns the SHA-256 of a pool file. The digest is cached in
+// a ".sha256" sidecar file, which is ignored once the pool file is newer.
+func (s *Server) poolChecksumOp(filename string) (Checksum, error) {
+	sum := Checksum{Filename: filename}
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return sum, err
+	}
+	path, err := s.securePoolPath(filename)
+	if err != nil {
+		return sum, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return sum, err
+	}
+	sum.Size = info.Size()
+
+	sidecar := path + checksumSuffix
+	if st, err := os.Stat(sidecar); err == nil && !st.ModTime().Before(info.ModTime()) {
+		if data, err := os.ReadFile(sidecar); err == nil {
+			if fields := strings.Fields(string(data)); len(fields) > 0 {
+				sum.SHA256 = fields[0]
+				return sum, nil
+			}
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	sum.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	// Same format as sha256sum(1)
+	if err := os.WriteFile(sidecar, []byte(sum.SHA256+"  "+filename+"\n"), 0644); err != nil {
+		slog.Warn("Failed to cache checksum", "file", filename, "err", err)
+	}
+	return sum, nil
+}
+
+// poolChecksumsOp returns the checksum of every pool file, using and
+// filling the .sha256 sidecar cache.
+func (s *Server) poolChecksumsOp() ([]Checksum, error) {
+	files, err := s.poolFiles()
+	if err != nil {
+		return nil, err
+	}
+	list := []Checksum{}
+	for _, f := range files {
+		sum, err := s.poolChecksumOp(f.Name)
+		if err != nil {
+			return nil, fmt.Errorf("checksum of %s: %w", f.Name, err)
+		}
+		list = append(list, sum)
+	}
+	return list, nil
+}
+
+// VerifyReport is the result of checking every pool file with dpkg-deb.
+type VerifyReport struct {
+	OK      []string          `json:"ok"`
+	Corrupt []string          `json:"corrupt"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// verifyPoolOp runs dpkg-deb --info on each pool file. Files rejected by
+// dpkg-deb are reported as corrupt, and deleted if deleteCorrupt is set.
+// Errors holds the reason for every file that did not pass.
+func (s *Server) verifyPoolOp(deleteCorrupt bool) (VerifyReport, error) {
+	report := VerifyReport{OK: []string{}, Corrupt: []string{}, Errors: map[string]string{}}
+	files, err := s.poolFiles()
+	if err != nil {
+		return report, err
+	}
+
+	for _, f := range files {
+		name := f.Name
+		out, err := combinedOutput(exec.Command("dpkg-deb", "--info", f.Path))
+		if err == nil {
+			report.OK = append(report.OK, name)
+			continue
+		}
+		report.Errors[name] = err.Error()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			continue
+		}
+		report.Corrupt = append(report.Corrupt, name)
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			report.Errors[name] = msg
+		}
+		if deleteCorrupt {
+			slog.Info("🗑️ Deleting corrupt pool file", "file", name)
+			if err := s.deletePoolFileOp(name); err != nil {
+				slog.Error("Failed to delete corrupt pool file", "file", name, "err", err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// poolExportOp returns a gzipped Packages index of the pool, generated by
+// dpkg-scanpackages with file names relative to PoolDir.
+func (s *Server) poolExportOp() ([]byte, error) {
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("dpkg-scanpackages", ".", "/dev/null")
+	cmd.Dir = s.cfg.PoolDir
+	index, err := output(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(index); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// poolReleaseOp returns a minimal, unsigned Release file for the pool
+// exported as a flat apt repository.
+func (s *Server) poolReleaseOp() ([]byte, error) {
+	packages, err := s.poolExportOp()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(packages)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Origin: groom\n")
+	fmt.Fprintf(&b, "Label: groom\n")
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "SHA256:\n %s %d Packages.gz\n", hex.EncodeToString(sum[:]), len(packages))
+	return b.Bytes(), nil
+}
+
+// PoolVersion is one version of a package available in the pool.
+type PoolVersion struct {
+	Version string `json:"version"`
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+}
+
+// PoolPackage lists the pool versions of a package, oldest first.
+type PoolPackage struct {
+	Versions []PoolVersion `json:"versions"`
+}
+
+// poolTreeOp groups the pool files by package name.
+func (s *Server) poolTreeOp() (map[string]PoolPackage, error) {
+	files, err := s.poolFiles()
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]PoolPackage{}
+	for _, d := range s.scanDebs(files) {
+		pkg := tree[d.Package]
+		pkg.Versions = append(pkg.Versions, PoolVersion{Version: d.Version, File: d.File, Size: d.Size})
+		tree[d.Package] = pkg
+	}
+	for _, pkg := range tree {
+		slices.SortFunc(pkg.Versions, func(a, b PoolVersion) int {
+			if c := versionOrder(a.Version, b.Version); c != 0 {
+				return c
+			}
+			return strings.Compare(a.File, b.File)
+		})
+	}
+	return tree, nil
+}
+
+// AptDeps reports whether the Depends of a pool file can be satisfied from
+// the installed system or the current apt cache.
+type AptDeps struct {
+	Satisfied bool     `json:"satisfied"`
+	Missing   []string `json:"missing"`
+	Available []string `json:"available"`
+}
+
+func (s *Server) poolAptDepsOp(filename string) (AptDeps, error) {
+	res := AptDeps{Missing: []string{}, Available: []string{}}
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return res, err
+	}
+	path, err := s.securePoolPath(filename)
+	if err != nil {
+		return res, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return res, err
+	}
+	depends, err := s.getControlField(path, "Depends")
+	if err != nil {
+		return res, fmt.Errorf("failed to read package info: %w", err)
+	}
+
+	for _, alternatives := range parseRelations(depends) {
+		found := ""
+		for _, spec := range alternatives {
+			if version := s.systemVersion(spec); version != "" {
+				found = spec.Name + " " + version
+				break
+			}
+		}
+		if found != "" {
+			res.Available = append(res.Available, found)
+			continue
+		}
+		var names []string
+		for _, spec := range alternatives {
+			names = append(names, strings.TrimSpace(spec.Name+" "+spec.Required()))
+		}
+		res.Missing = append(res.Missing, strings.Join(names, " | "))
+	}
+	res.Satisfied = len(res.Missing) == 0
+	return res, nil
+}
+
+// Duplicate lists the pool files holding the same package, newest first.
+type Duplicate struct {
+	Package  string   `json:"package"`
+	Files    []string `json:"files"`
+	Versions []string `json:"versions"`
+	Deleted  []string `json:"deleted,omitempty"`
+}
+
+// poolDuplicatesOp groups pool files by package name and returns the groups
+// with more than one file. With deleteOlder, all but the newest file of each
+// group are removed from the pool.
+func (s *Server) poolDuplicatesOp(deleteOlder bool) ([]Duplicate, error) {
+	files, err := s.poolFiles()
+	if err != nil {
+		return nil, err
+	}
+	byPackage := map[string][]debInfo{}
+	for _, d := range s.scanDebs(files) {
+		byPackage[d.Package] = append(byPackage[d.Package], d)
+	}
+
+	list := []Duplicate{}
+	for pkg, debs := range byPackage {
+		if len(debs) < 2 {
+			continue
+		}
+		slices.SortFunc(debs, func(a, b debInfo) int {
+			if c := versionOrder(b.Version, a.Version); c != 0 {
+				return c
+			}
+			return strings.Compare(a.File, b.File)
+		})
+		dup := Duplicate{Package: pkg}
+		for _, d := range debs {
+			dup.Files = append(dup.Files, d.File)
+			dup.Versions = append(dup.Versions, d.Version)
+		}
+		if deleteOlder {
+			for _, d := range debs[1:] {
+				slog.Info("🗑️ Deleting older duplicate", "file", d.File)
+				if err := s.deletePoolFileOp(d.File); err != nil {
+					slog.Error("Failed to delete duplicate pool file", "file", d.File, "err", err)
+					continue
+				}
+				dup.Deleted = append(dup.Deleted, d.File)
+			}
+		}
+		list = append(list, dup)
+	}
+	slices.SortFunc(list, func(a, b Duplicate) int { return strings.Compare(a.Package, b.Package) })
+	return list, nil
+}
+
+// systemVersion returns the installed or apt candidate version of spec's
+// package that satisfies spec, or "" if neither does.
+func (s *Server) systemVersion(spec depSpec) string {
+	if version := s.installedVersion(spec.Name); spec.satisfies(version) {
+		return version
+	}
+	if version := s.aptCandidate(spec.Name); spec.satisfies(version) {
+		return version
+	}
+	return ""
+}
+
+// poolMissingDepsOp reports, for each pool package, the Depends entries that
+// neither the system, the apt cache nor another pool file can satisfy.
+// Packages with no missing dependency are omitted.
+func (s *Server) poolMissingDepsOp() (map[string][]string, error) {
+	files, err := s.poolFiles()
+	if err != nil {
+		return nil, err
+	}
+	debs := s.scanDebs(files)
+	inPool := func(spec depSpec) bool {
+		for _, d := range debs {
+			if d.Package == spec.Name && spec.satisfies(d.Version) {
+				return true
+			}
+		}
+		return false
+	}
+
+	missing := map[string][]string{}
+	for _, d := range debs {
+		depends, err := s.getControlField(d.Path, "Depends")
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "file", d.File)
+			continue
+		}
+	relations:
+		for _, alternatives := range parseRelations(depends) {
+			var names []string
+			for _, spec := range alternatives {
+				if inPool(spec) || s.systemVersion(spec) != "" {
+					continue relations
+				}
+				names = append(names, strings.TrimSpace(spec.Name+" "+spec.Required()))
+			}
+			missing[d.Package] = append(missing[d.Package], strings.Join(names, " | "))
+		}
+	}
+	return missing, nil
+}
+
+// Conflict is an installed package listed in the Conflicts or Replaces
+// field of a pool file.
+type Conflict struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Status  string `json:"status"`
+	Action  string `json:"action"` // "conflict" or "replace"
+}
+
+// poolConflictsOp lists the installed packages that the pool file filename
+// conflicts with or replaces.
+func (s *Server) poolConflictsOp(filename string) ([]Conflict, error) {
+	list := []Conflict{}
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return list, err
+	}
+	path, err := s.securePoolPath(filename)
+	if err != nil {
+		return list, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return list, err
+	}
+
+	for _, field := range []struct{ name, action string }{
+		{"Conflicts", "conflict"},
+		{"Replaces", "replace"},
+	} {
+		value, err := s.getControlField(path, field.name)
+		if err != nil {
+			return list, fmt.Errorf("failed to read package info: %w", err)
+		}
+		for _, alternatives := range parseRelations(value) {
+			for _, spec := range alternatives {
+				version := s.installedVersion(spec.Name)
+				if !spec.satisfies(version) {
+					continue
+				}
+				list = append(list, Conflict{
+					Package: spec.Name,
+					Version: version,
+					Status:  "installed",
+					Action:  field.action,
+				})
+			}
+		}
+	}
+	return list, nil
+}
+
+func (s *Server) listInstalledOp() ([]string, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(s.cfg.InstalledDir)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".deb") {
+			list = append(list, f.Name())
+		}
+	}
+	return list, nil
+}
+
+// InstalledStats summarizes the packages tracked in InstalledDir.
+type InstalledStats struct {
+	TotalPackages     int   `json:"total_packages"`
+	TotalTrackedBytes int64 `json:"total_tracked_bytes"`
+	HasBackups        int   `json:"has_backups"`
+	HasBrokenInstalls int   `json:"has_broken_installs"`
+}
+
+func (s *Server) installedStatsOp() (InstalledStats, error) {
+	var stats InstalledStats
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return stats, err
+	}
+	files, err := os.ReadDir(s.cfg.InstalledDir)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(f.Name(), ".previous") {
+			stats.HasBackups++
+			continue
+		}
+		if !strings.HasSuffix(f.Name(), ".deb") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		stats.TotalPackages++
+		stats.TotalTrackedBytes += info.Size()
+
+		// Cross-check groom's record against dpkg
+		pkgName, err := s.getPackageName(filepath.Join(s.cfg.InstalledDir, f.Name()))
+		if err != nil || !s.isPackageInstalled(pkgName) {
+			stats.HasBrokenInstalls++
+		}
+	}
+	return stats, nil
+}
+
+// installedExportOp builds a Debian Packages index of the .deb files tracked
+// in InstalledDir. Unreadable files and import stubs are skipped.
+func (s *Server) installedExportOp() ([]byte, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	files, err := readFiles(s.cfg.InstalledDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var index bytes.Buffer
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name, ".deb") || s.isStubDeb(f.Path) {
+			continue
+		}
+		stanza, err := packagesStanza(f)
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "file", f.Name)
+			continue
+		}
+		if index.Len() > 0 {
+			index.WriteByte('\n')
+		}
+		index.Write(stanza)
+	}
+	return index.Bytes(), nil
+}
+
+// InstallRecord is a tracked package and the time it was committed to
+// InstalledDir.
+type InstallRecord struct {
+	File        string    `json:"file"`
+	Package     string    `json:"package"`
+	Version     string    `json:"version"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// installHistoryFile, in InstalledDir, gets one "<RFC 3339 time>\t<file>"
+// line from the installer script each time it commits a package.
+const installHistoryFile = ".install-history"
+
+// installTimes returns the last commit time of each file recorded in
+// installHistoryFile.
+func (s *Server) installTimes() (map[string]time.Time, error) {
+	times := map[string]time.Time{}
+	data, err := os.ReadFile(filepath.Join(s.cfg.InstalledDir, installHistoryFile))
+	if os.IsNotExist(err) {
+		return times, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		stamp, file, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, stamp); err == nil {
+			times[file] = t
+		}
+	}
+	return times, nil
+}
+
+// installedByDateOp lists the tracked packages committed within [since, until],
+// oldest first. A zero bound is open. Install times come from
+// installHistoryFile; files committed before it existed, or imported, fall
+// back to their modification time, which rollbacks and chmod preserve.
+func (s *Server) installedByDateOp(since, until time.Time) ([]InstallRecord, error) {
+	list := []InstallRecord{}
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return list, err
+	}
+	files, err := readFiles(s.cfg.InstalledDir)
+	if err != nil {
+		return list, err
+	}
+	times, err := s.installTimes()
+	if err != nil {
+		return list, err
+	}
+	for _, d := range s.scanDebs(files) {
+		at, ok := times[d.File]
+		if !ok {
+			info, err := os.Stat(d.Path)
+			if err != nil {
+				continue
+			}
+			at = info.ModTime()
+		}
+		if (!since.IsZero() && at.Before(since)) || (!until.IsZero() && at.After(until)) {
+			continue
+		}
+		list = append(list, InstallRecord{File: d.File, Package: d.Package, Version: d.Version, InstalledAt: at})
+	}
+	slices.SortFunc(list, func(a, b InstallRecord) int { return a.InstalledAt.Compare(b.InstalledAt) })
+	return list, nil
+}
+
+// ImportRequest identifies a package installed outside of groom.
+// Version and Architecture default to the installed ones.
+type ImportRequest struct {
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+}
+
+// debPackageName matches valid Debian package names.
+var debPackageName = regexp.MustCompile(`^[a-z0-9][a-z0-9+.\-]+$`)
+
+// aptArchivesDir is where apt keeps the package files it downloaded.
+const aptArchivesDir = "/var/cache/apt/archives"
+
+// importInstalledOp starts tracking a package that is installed but unknown
+// to groom. The package file is copied from the apt cache when available,
+// otherwise a stub .deb carrying only the control data is built. It returns
+// the name of the file created in InstalledDir.
+func (s *Server) importInstalledOp(req ImportRequest) (string, error) {
+	if !debPackageName.MatchString(req.Package) {
+		return "", fmt.Errorf("%w: bad package name %q", ErrInvalidFilename, req.Package)
+	}
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return "", err
+	}
+	version := s.installedVersion(req.Package)
+	if version == "" {
+		return "", fmt.Errorf("%s is not installed: %w", req.Package, os.ErrNotExist)
+	}
+	if req.Version != "" && req.Version != version {
+		return "", fmt.Errorf("%w: %s %s is installed, not %s", ErrConflict, req.Package, version, req.Version)
+	}
+	if s.findInstalledPackage(req.Package) != "" {
+		return "", fmt.Errorf("%w: %s is already tracked", ErrConflict, req.Package)
+	}
+	arch := req.Architecture
+	if arch == "" {
+		out, err := output(exec.Command("dpkg-query", "-W", "-f=${Architecture}", req.Package))
+		if err != nil {
+			return "", err
+		}
+		arch = strings.TrimSpace(string(out))
+	}
+
+	// Debian file names drop the epoch of the version
+	_, fileVersion, found := strings.Cut(version, ":")
+	if !found {
+		fileVersion = version
+	}
+	filename := fmt.Sprintf("%s_%s_%s.deb", req.Package, fileVersion, arch)
+	if filepath.Base(filename) != filename {
+		return "", fmt.Errorf("%w: %s", ErrInvalidFilename, filename)
+	}
+	target, err := s.installedPath(filename)
+	if err != nil {
+		return "", err
+	}
+
+	cached := filepath.Join(aptArchivesDir, fmt.Sprintf("%s_%s_%s.deb", req.Package, strings.ReplaceAll(version, ":", "%3a"), arch))
+	if src, err := os.Open(cached); err == nil {
+		defer src.Close()
+		dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, s.cfg.InstalledDirMode)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(dst, src)
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(target)
+			return "", err
+		}
+		slog.Info("📥 Imported from the apt cache", "package", req.Package, "version", version)
+		return filename, nil
+	}
+
+	if err := buildStubDeb(target, req.Package, version, arch); err != nil {
+		return "", err
+	}
+	os.Chmod(target, s.cfg.InstalledDirMode)
+	slog.Info("📥 Imported as a stub package", "package", req.Package, "version", version)
+	return filename, nil
+}
+
+// installedSignaturesOp reports the signature state of every .deb tracked
+// in InstalledDir, by file name. Import stubs carry no signature to check.
+func (s *Server) installedSignaturesOp() (map[string]string, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	files, err := readFiles(s.cfg.InstalledDir)
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]string{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, ".deb") && !s.isStubDeb(f.Path) {
+			states[f.Name] = debSignatureStatus(f.Path)
+		}
+	}
+	return states, nil
+}
+
+// installedChangelogOp returns the Debian changelog of the tracked package
+// pkgName. Errors wrap os.ErrNotExist if the package is not tracked or has
+// no changelog.
+func (s *Server) installedChangelogOp(pkgName string) ([]byte, error) {
+	debPath := s.findInstalledPackage(pkgName)
+	if debPath == "" {
+		return nil, fmt.Errorf("%s is not tracked: %w", pkgName, os.ErrNotExist)
+	}
+	return debChangelog(debPath)
+}
+
+// Dependency describes one Depends entry of a package and whether the
+// system currently satisfies it.
+type Dependency struct {
+	Package   string `json:"package"`
+	Required  string `json:"required,omitempty"`
+	Installed string `json:"installed,omitempty"`
+	Satisfied bool   `json:"satisfied"`
+}
+
+// installedScriptsOp returns the maintainer scripts of the tracked package
+// file filename.
+func (s *Server) installedScriptsOp(filename string) (map[string]string, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	installedPath, err := s.installedPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(installedPath); err != nil {
+		return nil, err
+	}
+	return debMaintainerScripts(installedPath)
+}
+
+func (s *Server) installedDependsOp(filename string) ([]Dependency, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	installedPath, err := s.installedPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(installedPath); err != nil {
+		return nil, err
+	}
+	depends, err := s.getControlField(installedPath, "Depends")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package info: %w", err)
+	}
+
+	list := []Dependency{}
+	for _, alternatives := range parseRelations(depends) {
+		// Report the first satisfied alternative, or the first one if none is.
+		var dep Dependency
+		for i, spec := range alternatives {
+			version := s.installedVersion(spec.Name)
+			candidate := Dependency{
+				Package:   spec.Name,
+				Required:  spec.Required(),
+				Installed: version,
+				Satisfied: spec.satisfies(version),
+			}
+			if i == 0 || candidate.Satisfied {
+				dep = candidate
+			}
+			if candidate.Satisfied {
+				break
+			}
+		}
+		list = append(list, dep)
+	}
+	return list, nil
+}
+
+// removeOrphansOp deletes tracking files whose package dpkg reports as not
+// installed, e.g. after a manual apt-get purge. It returns the number removed.
+func (s *Server) removeOrphansOp() (int, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return 0, err
+	}
+	files, err := readFiles(s.cfg.InstalledDir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name, ".deb") {
+			continue
+		}
+		pkgName, err := s.getPackageName(f.Path)
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "file", f.Name)
+			continue
+		}
+		switch status := s.packageStatus(pkgName); {
+		case status == "", strings.HasSuffix(status, " not-installed"), strings.HasSuffix(status, " config-files"):
+		default:
+			continue
+		}
+		slog.Info("🧹 Forgetting orphan", "file", f.Name, "package", pkgName)
+		if err := os.Remove(f.Path); err != nil {
+			slog.Error("Failed to remove orphan", "file", f.Name, "err", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Repair describes a reinstallation scheduled by repairInstalledOp.
+type Repair struct {
+	Filename string `json:"filename"`
+	Package  string `json:"package"`
+	Unit     string `json:"unit,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// repairInstalledOp finds packages tracked in InstalledDir that dpkg no longer
+// reports as installed (e.g. after a manual apt-get remove) and schedules their
+// reinstallation by copying the tracked file back into the pool.
+func (s *Server) repairInstalledOp() ([]Repair, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(s.cfg.InstalledDir)
+	if err != nil {
+		return nil, err
+	}
+
+	repairs := []Repair{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".deb") {
+			continue
+		}
+		installedPath := filepath.Join(s.cfg.InstalledDir, f.Name())
+		pkgName, err := s.getPackageName(installedPath)
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "file", f.Name())
+			continue
+		}
+		if s.isPackageInstalled(pkgName) {
+			continue
+		}
+
+		slog.Info("🩹 Repairing package tracked but not installed", "package", pkgName)
+		repair := Repair{Filename: f.Name(), Package: pkgName}
+		if s.isStubDeb(installedPath) {
+			repair.Error = "imported stub, no package file to re-install"
+		} else if err := s.restoreToPool(installedPath); err != nil {
+			repair.Error = err.Error()
+		} else if unitName, err := s.scheduleInstallOp(f.Name()); err != nil {
+			repair.Error = err.Error()
+		} else {
+			repair.Unit = unitName
+		}
+		repairs = append(repairs, repair)
+	}
+	return repairs, nil
+}
+
+// restoreToPool copies a tracked installed file back into the pool.
+func (s *Server) restoreToPool(installedPath string) error {
+	in, err := os.Open(installedPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return s.uploadPoolOp(filepath.Base(installedPath), in)
+}
+
+// Outdated describes a tracked package for which the pool holds a newer version.
+type Outdated struct {
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installed_version"`
+	AvailableVersion string `json:"available_version"`
+	PoolFile         string `json:"pool_file"`
+}
+
+func (s *Server) outdatedOp() ([]Outdated, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return nil, err
+	}
+	installedFiles, err := readFiles(s.cfg.InstalledDir)
+	if err != nil {
+		return nil, err
+	}
+	poolFiles, err := s.poolFiles()
+	if err != nil {
+		return nil, err
+	}
+	installed := s.scanDebs(installedFiles)
+	pool := s.scanDebs(poolFiles)
+
+	list := []Outdated{}
+	for _, inst := range installed {
+		var newest *debInfo
+		for i, p := range pool {
+			if p.Package != inst.Package || !compareVersions(p.Version, ">>", inst.Version) {
+				continue
+			}
+			if newest == nil || compareVersions(p.Version, ">>", newest.Version) {
+				newest = &pool[i]
+			}
+		}
+		if newest != nil {
+			list = append(list, Outdated{
+				Package:          inst.Package,
+				InstalledVersion: inst.Version,
+				AvailableVersion: newest.Version,
+				PoolFile:         newest.File,
+			})
+		}
+	}
+	return list, nil
+}
+
+func (s *Server) scheduleInstallOp(poolFilename string) (string, error) {
+	if err := ensureDir(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode)); err != nil {
+		return "", err
+	}
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return "", err
+	}
+	sourcePath, err := s.sourcePath(poolFilename)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkSignature(sourcePath); err != nil {
+		return "", err
+	}
+
+	// Leave room for the package to be unpacked onto the root filesystem
+	if err := checkDiskSpace("/", 3*info.Size()); err != nil {
+		return "", err
+	}
+
+	// Identify Package Name to find potential conflicts/upgrades
+	pkgName, err := s.getPackageName(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("invalid deb file: %w", err)
+	}
+	if s.cfg.DisableSelfUpdate && s.cfg.SelfPackageName != "" && pkgName == s.cfg.SelfPackageName {
+		return "", fmt.Errorf("%w: self-update is disabled", ErrForbidden)
+	}
+
+	// Construct a unique unit name for systemd-run, and refuse to launch a
+	// second installer for the same package while the first is still active.
+	unitName := fmt.Sprintf("%s-install-%s", s.cfg.UnitNamePrefix, pkgName)
+	job := &installJob{Started: time.Now()}
+	// Installed-Size is in KiB
+	if kib, err := s.getControlField(sourcePath, "Installed-Size"); err == nil {
+		if n, err := strconv.ParseInt(kib, 10, 64); err == nil {
+			job.Bytes = n << 10
+		}
+	}
+	if _, active := s.activeUnits.LoadOrStore(unitName, job); active {
+		return "", ErrConflict
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			s.activeUnits.Delete(unitName)
+		}
+	}()
+
+	// Paths configuration
+	targetDeb, err := s.installedPath(poolFilename)
+	if err != nil {
+		return "", err
+	}
+	currentDeb := s.findInstalledPackage(pkgName)
+
+	// Upgrades replace a tracked package, new packages add one
+	if s.cfg.MaxInstalledPackages > 0 && currentDeb == "" {
+		tracked, err := s.listInstalledOp()
+		if err != nil {
+			return "", err
+		}
+		if len(tracked) >= s.cfg.MaxInstalledPackages {
+			return "", fmt.Errorf("%w: %d packages tracked, limit is %d", ErrInsufficientStorage, len(tracked), s.cfg.MaxInstalledPackages)
+		}
+	}
+	backupDeb := ""
+	if currentDeb != "" {
+		backupDeb = currentDeb + ".previous"
+	}
+
+	if err := s.runPreflight(); err != nil {
+		return "", err
+	}
+
+	// Generate the ephemeral installer script
+	version, _ := s.getControlField(sourcePath, "Version")
+	scriptContent := fmt.Sprintf(installerScriptTemplate, sourcePath, targetDeb, currentDeb, backupDeb,
+		s.cfg.StagingDir, pkgName, version, s.cfg.InstalledDirMode,
+		filepath.Join(s.cfg.InstalledDir, installHistoryFile), shellQuoteAll(s.cfg.PostCommitCmd),
+		shellQuoteAll(s.cfg.Hooks[HookPreInstall]),
+		shellQuoteAll(s.cfg.Hooks[HookPostInstall]),
+		shellQuoteAll(s.cfg.Hooks[HookTransactionCommitted]),
+		shellQuoteAll(s.cfg.Hooks[HookTransactionFailed]))
+	scriptDir := os.TempDir()
+	if s.cfg.InstallScriptDir != "" {
+		if err := os.MkdirAll(s.cfg.InstallScriptDir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create installer script dir: %w", err)
+		}
+		scriptDir = s.cfg.InstallScriptDir
+	}
+	scriptPath := filepath.Join(scriptDir, fmt.Sprintf("%s_install_%s.sh", s.cfg.UnitNamePrefix, pkgName))
+
+	if err := os.WriteFile(scriptPath, []byte(scriptContent), 0755); err != nil {
+		return "", fmt.Errorf("failed to create installer script: %w", err)
+	}
+
+	slog.Info("🚀 Launching detached installation", "package", pkgName, "unit", unitName)
+
+	// Launch via systemd-run
+	args := []string{
+		"--unit=" + unitName,
+		"--description=Groom Service Installer Worker for " + pkgName,
+		"--service-type=oneshot",
+		// Allow the script to live even if groom dies (which happens during self-update)
+		"--collect",
+	}
+	args = append(args, s.cfg.SystemdRunFlags...)
+	cmd := exec.Command("systemd-run", append(args, scriptPath)...)
+
+	s.holdForUnit()
+	if output, err := combinedOutput(cmd); err != nil {
+		s.releaseUnit()
+		return "", fmt.Errorf("%s", string(output))
+	}
+
+	launched = true
+	go s.watchUnit(unitName, scriptPath)
+	return unitName, nil
+}
+
+// runPreflight runs Config.PreflightCmd, if any. A failure is reported as
+// ErrPreconditionFailed with the command's output.
+func (s *Server) runPreflight() error {
+	if len(s.cfg.PreflightCmd) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	out, err := combinedOutput(exec.CommandContext(ctx, s.cfg.PreflightCmd[0], s.cfg.PreflightCmd[1:]...))
+	if err != nil {
+		slog.Warn("⛔ Preflight command failed", "err", err)
+		return fmt.Errorf("%w: %v: %s", ErrPreconditionFailed, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runPostCommit runs Config.PostCommitCmd, if any, after a successful
+// package change. Failures are only logged.
+func (s *Server) runPostCommit(installed, removed []string) {
+	if len(s.cfg.PostCommitCmd) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postCommitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.cfg.PostCommitCmd[0], s.cfg.PostCommitCmd[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GROOM_PACKAGES_INSTALLED="+strings.Join(installed, " "),
+		"GROOM_PACKAGES_REMOVED="+strings.Join(removed, " "),
+	)
+	if out, err := combinedOutput(cmd); err != nil {
+		slog.Error("⚠️ Post-commit command failed", "err", err, "output", strings.TrimSpace(string(out)))
+	}
+}
+
+// runHook runs the Config.Hooks command of event, if any. Failures are
+// only logged.
+func (s *Server) runHook(event, pkgName, version string) {
+	hook := s.cfg.Hooks[event]
+	if len(hook) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook[0], hook[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GROOM_EVENT="+event,
+		"GROOM_PACKAGE="+pkgName,
+		"GROOM_VERSION="+version,
+	)
+	if out, err := combinedOutput(cmd); err != nil {
+		slog.Error("⚠️ Hook failed", "event", event, "err", err, "output", strings.TrimSpace(string(out)))
+	}
+}
+
+// shellQuoteAll quotes each of args for bash and joins them with spaces.
+func shellQuoteAll(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// watchUnit polls systemd until unitName is no longer running, then releases
+// its entry in activeUnits and its hold on draining. With InstallScriptDir set, the finished unit's
+// script is kept as <unit>-<timestamp>.sh.done for post-mortem inspection.
+func (s *Server) watchUnit(unitName, scriptPath string) {
+	defer s.activeUnits.Delete(unitName)
+	defer s.releaseUnit()
+
+	ticker := time.NewTicker(unitPollInterval)
+	defer ticker.Stop()
+	for {
+		// is-active exits non-zero for "activating", so only the state matters.
+		out, _ := output(exec.Command("systemctl", "is-active", unitName))
+		switch strings.TrimSpace(string(out)) {
+		case "active", "activating", "deactivating", "reloading":
+		default:
+			s.archiveScript(unitName, scriptPath)
+			return
+		}
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SimulateRequest lists the changes to dry-run: pool files to install and
+// package names to remove.
+type SimulateRequest struct {
+	Install []string `json:"install"`
+	Remove  []string `json:"remove"`
+}
+
+// Simulation is apt-get's dry-run output for a SimulateRequest.
+type Simulation struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output"`
+}
+
+// simulateOp runs apt-get -s for the requested changes, without touching
+// the system or systemd. Missing pool files are reported as os.ErrNotExist,
+// names that are not Debian package names as ErrInvalidFilename.
+func (s *Server) simulateOp(req SimulateRequest) (Simulation, error) {
+	res := Simulation{OK: true}
+	// A name such as "-oAPT::Get::Simulate=false" would otherwise be read
+	// as an option and turn the dry run into a real removal.
+	for _, name := range req.Remove {
+		if !debPackageName.MatchString(name) {
+			return res, fmt.Errorf("%w: invalid package name %q", ErrInvalidFilename, name)
+		}
+	}
+	var paths []string
+	for _, name := range req.Install {
+		path, err := s.sourcePath(name)
+		if err != nil {
+			return res, err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return res, err
+		}
+		paths = append(paths, path)
+	}
+
+	var out strings.Builder
+	run := func(args ...string) {
+		b, err := combinedOutput(exec.Command("apt-get", args...))
+		out.Write(b)
+		if err != nil {
+			res.OK = false
+			fmt.Fprintf(&out, "apt-get %s: %v\n", args[0], err)
+		}
+	}
+	if len(paths) > 0 {
+		run(append([]string{"install", "-s", "-y", "--"}, paths...)...)
+	}
+	if len(req.Remove) > 0 {
+		run(append([]string{"remove", "-s", "-y", "--"}, req.Remove...)...)
+	}
+	res.Output = out.String()
+	return res, nil
+}
+
+// installJob is an installer unit tracked in Server.activeUnits.
+type installJob struct {
+	Started time.Time
+	Bytes   int64 // uncompressed size of the package
+}
+
+// ETA estimates the remaining time of the running installs.
+type ETA struct {
+	EstimatedSeconds  int64 `json:"estimated_seconds"`
+	PackagesRemaining int   `json:"packages_remaining"`
+}
+
+// etaOp estimates when the running installs complete, assuming each one
+// unpacks at InstallRateBytesPerSecond from its start.
+func (s *Server) etaOp() ETA {
+	var eta ETA
+	s.activeUnits.Range(func(_, v any) bool {
+		job := v.(*installJob)
+		eta.PackagesRemaining++
+		total := time.Duration(float64(job.Bytes) / float64(s.cfg.InstallRateBytesPerSecond) * float64(time.Second))
+		if left := total - time.Since(job.Started); left > 0 {
+			eta.EstimatedSeconds = max(eta.EstimatedSeconds, int64(left.Round(time.Second).Seconds()))
+		}
+		return true
+	})
+	return eta
+}
+
+// Unit is a systemd unit as reported by systemctl list-units.
+type Unit struct {
+	Unit        string `json:"unit"`
+	Load        string `json:"load"`
+	Active      string `json:"active"`
+	Sub         string `json:"sub"`
+	Description string `json:"description"`
+}
+
+// listUnitsOp lists the systemd units named after UnitNamePrefix.
+func (s *Server) listUnitsOp() ([]Unit, error) {
+	out, err := output(exec.Command("systemctl", "list-units", s.cfg.UnitNamePrefix+"-*", "--all", "--no-legend", "--output=json"))
+	if err != nil {
+		return nil, err
+	}
+	units := []Unit{}
+	if err := json.Unmarshal(out, &units); err == nil {
+		return units, nil
+	}
+
+	// Older systemd versions ignore --output=json for tables: parse the columns.
+	units = []Unit{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(strings.TrimLeft(line, "●* "))
+		if len(fields) < 4 {
+			continue
+		}
+		units = append(units, Unit{
+			Unit:        fields[0],
+			Load:        fields[1],
+			Active:      fields[2],
+			Sub:         fields[3],
+			Description: strings.Join(fields[4:], " "),
+		})
+	}
+	return units, nil
+}
+
+// scriptDoneSuffix marks installer scripts whose unit has completed.
+const scriptDoneSuffix = ".sh.done"
+
+func (s *Server) archiveScript(unitName, scriptPath string) {
+	if s.cfg.InstallScriptDir == "" {
+		return
+	}
+	done := filepath.Join(s.cfg.InstallScriptDir,
+		fmt.Sprintf("%s-%s%s", unitName, time.Now().UTC().Format("20060102T150405Z"), scriptDoneSuffix))
+	if err := os.Rename(scriptPath, done); err != nil {
+		slog.Error("Failed to archive installer script", "script", scriptPath, "err", err)
+	}
+}
+
+func (s *Server) listScriptsOp() ([]string, error) {
+	if s.cfg.InstallScriptDir == "" {
+		return nil, ErrNotConfigured
+	}
+	if err := ensureDir(s.cfg.InstallScriptDir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := readFiles(s.cfg.InstallScriptDir)
+	if err != nil {
+		return nil, err
+	}
+	list := []string{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, scriptDoneSuffix) {
+			list = append(list, f.Name)
+		}
+	}
+	return list, nil
+}
+
+func (s *Server) readScriptOp(name string) ([]byte, error) {
+	if s.cfg.InstallScriptDir == "" {
+		return nil, ErrNotConfigured
+	}
+	if !strings.HasSuffix(name, scriptDoneSuffix) {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(s.cfg.InstallScriptDir, name))
+}
+
+func (s *Server) removePackageOp(filename string) (string, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return "", err
+	}
+	installedPath, err := s.installedPath(filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(installedPath); err != nil {
+		return "", err
+	}
+
+	pkgName, err := s.getPackageName(installedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read package info: %w", err)
+	}
+
+	// Prevent suicide: do not allow removing the agent itself
+	if pkgName == s.cfg.SelfPackageName {
+		return "", ErrForbidden
+	}
+
+	version, _ := s.getControlField(installedPath, "Version")
+	s.runHook(HookPreRemove, pkgName, version)
+	slog.Info("🗑️ Removing package", "package", pkgName)
+	cmd := exec.Command("apt-get", "remove", "-y", "--", pkgName)
+	if out, err := combinedOutput(cmd); err != nil {
+		s.runHook(HookTransactionFailed, pkgName, version)
+		return "", fmt.Errorf("remove failed: %s: %w", string(out), err)
+	}
+	s.runHook(HookPostRemove, pkgName, version)
+
+	// Remove record from installed
+	os.Remove(installedPath)
+	s.controls.forget(installedPath)
+	s.runHook(HookTransactionCommitted, pkgName, version)
+	s.runPostCommit(nil, []string{pkgName})
+	return pkgName, nil
+}
+
+func (s *Server) purgeInstalledOp() (int, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return 0, err
+	}
+	files, err := os.ReadDir(s.cfg.InstalledDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var purged []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".deb") {
+			fullPath := filepath.Join(s.cfg.InstalledDir, f.Name())
+			pkgName, err := s.getPackageName(fullPath)
+			if err != nil {
+				slog.Warn("Skipping unreadable file", "file", f.Name())
+				continue
+			}
+
+			// Protect Groom
+			if pkgName == s.cfg.SelfPackageName {
+				continue
+			}
+
+			version, _ := s.getControlField(fullPath, "Version")
+			s.runHook(HookPreRemove, pkgName, version)
+			slog.Info("🔥 Purging package", "package", pkgName)
+			// Purge to remove config files too
+			cmd := exec.Command("apt-get", "purge", "-y", "--", pkgName)
+			if out, err := combinedOutput(cmd); err != nil {
+				slog.Error("Failed to purge package", "package", pkgName, "output", string(out))
+				s.runHook(HookTransactionFailed, pkgName, version)
+				continue
+			}
+			s.runHook(HookPostRemove, pkgName, version)
+			os.Remove(fullPath)
+			s.controls.forget(fullPath)
+			s.runHook(HookTransactionCommitted, pkgName, version)
+			purged = append(purged, pkgName)
+		}
+	}
+	if len(purged) > 0 {
+		s.runPostCommit(nil, purged)
+	}
+	return len(purged), nil
+}
+
+// cleanupBackupsOp deletes ".previous" backups in InstalledDir older than maxAge.
+// It returns the number of files removed.
+func (s *Server) cleanupBackupsOp(maxAge time.Duration) (int, error) {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return 0, err
+	}
+	files, err := os.ReadDir(s.cfg.InstalledDir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".previous") {
+			continue
+		}
+		info, err := f.Info()
+		// The installer renames backups into place, which keeps their original
+		// modification time but updates their change time.
+		if err != nil || time.Since(changeTime(info)) < maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.cfg.InstalledDir, f.Name())); err != nil {
+			slog.Error("Failed to remove stale backup", "file", f.Name(), "err", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// dirMode returns the directory permissions matching the file mode m: every
+// class that may read files may also traverse the directory.
+func dirMode(m os.FileMode) os.FileMode {
+	return m | (m&0444)>>2
+}
+
+// ensureDir recreates the directory at path, with permissions mode, if it
+// was deleted while the daemon is running.
+func ensureDir(path string, mode os.FileMode) error {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		slog.Warn("⚠️ Directory is missing, recreating it", "path", path)
+		return os.MkdirAll(path, mode)
+	}
+	return err
+}
+
+// checkDiskSpace returns ErrInsufficientStorage if the filesystem holding
+// path has less than required bytes available.
+func checkDiskSpace(path string, required int64) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+	if free := int64(st.Bavail) * int64(st.Bsize); free < required {
+		return fmt.Errorf("%w: %d bytes free on %s, %d required", ErrInsufficientStorage, free, path, required)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// moveFile atomically renames src to dst. When they are on different
+// filesystems, src is copied to a temporary file next to dst, renamed into
+// place, and then deleted.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// changeTime returns the inode change time of info, or its modification time
+// when unavailable.
+func changeTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctim.Unix())
+	}
+	return info.ModTime()
+}
+
+// debInfo identifies a .deb file by its control data.
+type debInfo struct {
+	File    string
+	Path    string
+	Package string
+	Version string
+	Size    int64
+}
+
+// scanDebs reads the package name and version of every .deb file in files.
+// Unreadable files are skipped.
+func (s *Server) scanDebs(files []fileEntry) []debInfo {
+	var list []debInfo
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name, ".deb") {
+			continue
+		}
+		pkgName, err := s.getPackageName(f.Path)
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "file", f.Name)
+			continue
+		}
+		version, err := s.getControlField(f.Path, "Version")
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "file", f.Name)
+			continue
+		}
+		list = append(list, debInfo{File: f.Name, Path: f.Path, Package: pkgName, Version: version, Size: f.Info.Size()})
+	}
+	return list
+}
+
+func (s *Server) getPackageName(debPath string) (string, error) {
+	// dpkg-deb -f file Package
+	return s.getControlField(debPath, "Package")
+}
+
+// isPackageInstalled reports whether dpkg considers pkgName fully installed.
+func (s *Server) isPackageInstalled(pkgName string) bool {
+	return s.packageStatus(pkgName) == "install ok installed"
+}
+
+// packageStatus returns dpkg's status for pkgName (e.g. "install ok installed"),
+// or "" if dpkg does not know the package.
+func (s *Server) packageStatus(pkgName string) string {
+	out, err := output(exec.Command("dpkg-query", "-W", "-f=${Status}", pkgName))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (s *Server) findInstalledPackage(pkgName string) string {
+	if err := ensureDir(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode)); err != nil {
+		return ""
+	}
+	files, err := os.ReadDir(s.cfg.InstalledDir)
+	if err != nil {
+		return ""
+	}
+
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".deb") {
+			path := filepath.Join(s.cfg.InstalledDir, f.Name())
+			name, err := s.getPackageName(path)
+			if err == nil && name == pkgName {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// combinedOutput runs cmd and returns its combined stdout and stderr.
+// The invocation and its result are logged at debug level.
+func combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	out, err := cmd.CombinedOutput()
+	slog.Debug("subprocess", "args", cmd.Args, "output", string(out), "err", err)
+	return out, err
+}
+
+// output runs cmd and returns its stdout.
+// The invocation and its result, including stderr on failure, are logged at debug level.
+func output(cmd *exec.Cmd) ([]byte, error) {
+	out, err := cmd.Output()
+	var stderr []byte
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		stderr = exitErr.Stderr
+	}
+	slog.Debug("subprocess", "args", cmd.Args, "stdout", string(out), "stderr", string(stderr), "err", err)
+	return out, err
 }
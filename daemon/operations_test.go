@@ -0,0 +1,66 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListRecreatesDeletedDirs(t *testing.T) {
+	root := t.TempDir()
+	s := New(Config{
+		PoolDir:      filepath.Join(root, "pool"),
+		InstalledDir: filepath.Join(root, "installed"),
+	})
+
+	tests := []struct {
+		name string
+		dir  string
+		list func() ([]string, error)
+	}{
+		{"installed", s.cfg.InstalledDir, s.listInstalledOp},
+		{"pool", s.cfg.PoolDir, s.listPoolOp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.MkdirAll(tt.dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(tt.dir, "a_1.0_all.deb"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.RemoveAll(tt.dir); err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := tt.list()
+			if err != nil {
+				t.Fatalf("list after deletion: %v", err)
+			}
+			if len(list) != 0 {
+				t.Errorf("list after deletion = %v, want empty", list)
+			}
+			info, err := os.Stat(tt.dir)
+			if err != nil {
+				t.Fatalf("directory not recreated: %v", err)
+			}
+			if !info.IsDir() {
+				t.Errorf("%s is not a directory", tt.dir)
+			}
+		})
+	}
+}
+
+func TestEnsureDirUsesMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "installed")
+	if err := ensureDir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0700 {
+		t.Errorf("mode = %04o, want 0700", got)
+	}
+}
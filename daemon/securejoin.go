@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secureJoin joins name to base and resolves symlinks in the result. It
+// fails with ErrInvalidFilename if the resolved path escapes base, so a
+// symlink planted in a managed directory cannot redirect reads, writes or
+// deletions to arbitrary files. base itself may be a symlink.
+func secureJoin(base, name string) (string, error) {
+	root, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(root, name)
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		// A file about to be created: it must not be a dangling symlink,
+		// and its directory must resolve inside root.
+		if _, lerr := os.Lstat(path); lerr == nil {
+			return "", fmt.Errorf("%w: %s is a dangling symlink", ErrInvalidFilename, name)
+		}
+		dir, derr := filepath.EvalSymlinks(filepath.Dir(path))
+		if derr != nil {
+			return "", derr
+		}
+		resolved, err = filepath.Join(dir, filepath.Base(path)), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s escapes %s", ErrInvalidFilename, name, base)
+	}
+	return resolved, nil
+}
+
+// installedPath returns the path of the tracked file filename in InstalledDir.
+func (s *Server) installedPath(filename string) (string, error) {
+	return secureJoin(s.cfg.InstalledDir, filename)
+}
@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "pool")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{base, outside, filepath.Join(base, "amd64")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(base, "a.deb"), filepath.Join(outside, "secret")} {
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"escape.deb":   filepath.Join(outside, "secret"),
+		"dangling.deb": filepath.Join(root, "missing"),
+		"inside.deb":   "a.deb",
+		"outdir":       outside,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(base, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	linkedBase := filepath.Join(root, "linked")
+	if err := os.Symlink(base, linkedBase); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		base string
+		file string
+		want string // empty when ErrInvalidFilename is expected
+	}{
+		{"plain file", base, "a.deb", filepath.Join(base, "a.deb")},
+		{"new file", base, "new.deb", filepath.Join(base, "new.deb")},
+		{"subdirectory", base, "amd64/b.deb", filepath.Join(base, "amd64", "b.deb")},
+		{"symlink inside", base, "inside.deb", filepath.Join(base, "a.deb")},
+		{"symlinked base", linkedBase, "a.deb", filepath.Join(base, "a.deb")},
+		{"escaping symlink", base, "escape.deb", ""},
+		{"symlinked directory", base, "outdir/x.deb", ""},
+		{"dangling symlink", base, "dangling.deb", ""},
+		{"dot dot", base, "../outside/secret", ""},
+		{"dot dot new file", base, "../new.deb", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secureJoin(tt.base, tt.file)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("secureJoin(%q) = %q, want an error", tt.file, got)
+				}
+				if !errors.Is(err, ErrInvalidFilename) {
+					t.Errorf("secureJoin(%q) error = %v, want ErrInvalidFilename", tt.file, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("secureJoin(%q): %v", tt.file, err)
+			}
+			if want, _ := filepath.EvalSymlinks(filepath.Dir(tt.want)); got != filepath.Join(want, filepath.Base(tt.want)) {
+				t.Errorf("secureJoin(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,32 +2,272 @@ package daemon
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// Default HTTP server timeouts, used when the corresponding Config field is zero.
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	// DefaultOperationTimeout bounds requests that wait for apt or dpkg.
+	DefaultOperationTimeout = 30 * time.Minute
+)
+
+// Default retention policy for installer ".previous" backups.
+const (
+	DefaultBackupRetentionCheckInterval = time.Hour
+	DefaultBackupRetentionDuration      = 48 * time.Hour
+)
+
+// DefaultPoolScanInterval is how often the package metadata cache is refreshed.
+const DefaultPoolScanInterval = 60 * time.Second
+
+// DefaultUnitNamePrefix starts the name of installer systemd units.
+const DefaultUnitNamePrefix = "groom"
+
+// DefaultInstallRateBytesPerSecond is the assumed unpack rate used by
+// GET /transaction/eta.
+const DefaultInstallRateBytesPerSecond = 10 << 20
+
+// DefaultFileMode is the permission of files created in PoolDir and InstalledDir.
+const DefaultFileMode os.FileMode = 0644
+
+// DefaultMaxJSONBodyBytes limits request bodies outside /pool/.
+const DefaultMaxJSONBodyBytes = 1 << 20
+
+// DefaultPprofAddr is the loopback address the profiling endpoint listens on.
+const DefaultPprofAddr = "127.0.0.1:6060"
+
 // Config holds the configuration parameters for the Daemon Server.
 type Config struct {
-	ListenAddr      string
-	Version         string
+	ListenAddr string
+	Version    string
+	// SelfPackageName is the package protected from removal. At Start it is
+	// replaced by the package owning the groom executable, when dpkg knows it.
 	SelfPackageName string
 	PoolDir         string
 	InstalledDir    string
+
+	// ArchSubdirs stores uploaded pool files in PoolDir/<architecture>/.
+	// Existing top-level pool files are migrated when the server starts.
+	ArchSubdirs bool
+	// ReleaseDir receives pool files promoted with POST /pool/{filename}/promote.
+	// Installs can be scheduled from either directory. Empty disables promotion.
+	ReleaseDir string
+	// InstallScriptDir keeps installer scripts instead of os.TempDir(). Scripts
+	// of completed units are renamed <unit>-<timestamp>.sh.done and listed by
+	// GET /scripts/.
+	InstallScriptDir string
+	// MaxInstalledPackages caps how many packages are tracked in InstalledDir.
+	// Zero means unlimited.
+	MaxInstalledPackages int
+	// BindInterface restricts the HTTP listener to the first IPv4 address of
+	// the named network interface, keeping the port from ListenAddr.
+	BindInterface string
+	// Hostname advertised over mDNS. Defaults to os.Hostname() when empty.
+	Hostname string
+
+	// HTTP server timeouts. Zero values are replaced by their defaults.
+	// Request bodies are not bounded by ReadHeaderTimeout.
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// OperationTimeout bounds reading and writing on the routes that stream
+	// packages or wait for apt, dpkg or the installer unit: uploads,
+	// downloads, pool scans and POST and DELETE /installed/.
+	OperationTimeout time.Duration
+
+	// Stale ".previous" backups in InstalledDir older than BackupRetentionDuration
+	// are deleted every BackupRetentionCheckInterval. Zero values use the defaults.
+	BackupRetentionCheckInterval time.Duration
+	BackupRetentionDuration      time.Duration
+
+	// EnablePprof serves net/http/pprof on PprofAddr, a listener separate from
+	// ListenAddr. PprofAddr defaults to DefaultPprofAddr.
+	EnablePprof bool
+	PprofAddr   string
+
+	// AllowedOrigins lists the origins allowed to call the API from a browser.
+	// "*" allows any origin. Empty disables CORS headers.
+	AllowedOrigins []string
+
+	// AuditLogFile, when set, receives one JSON line per state-changing request.
+	AuditLogFile string
+	// AuditLogMaxSizeMB rotates the audit log before it exceeds this size,
+	// keeping AuditLogMaxBackups old files (AuditLogFile.1 being the newest).
+	// Zero disables rotation.
+	AuditLogMaxSizeMB  int
+	AuditLogMaxBackups int
+
+	// HealthCheckExtensions are run by GET /health and reported by name.
+	HealthCheckExtensions []HealthChecker
+
+	// UnixSocketPath, when set, also serves the API on a Unix domain socket
+	// (mode 0660). With an empty ListenAddr, the socket is the only listener
+	// and mDNS advertising is disabled.
+	UnixSocketPath string
+
+	// PreflightCmd is run, with a 30 second timeout, before every installer
+	// launch. A non-zero exit refuses the install with 412 Precondition
+	// Failed. Empty means no preflight.
+	PreflightCmd []string
+	// PostCommitCmd is run, with a 10 second timeout, after a package was
+	// successfully installed or removed. It receives the package names in
+	// GROOM_PACKAGES_INSTALLED and GROOM_PACKAGES_REMOVED. Failures are only
+	// logged.
+	PostCommitCmd []string
+
+	// DisableSelfUpdate refuses API installs of SelfPackageName with 403.
+	DisableSelfUpdate bool
+
+	// RequireSignedDebs rejects uploads and installs of packages whose
+	// signature is not verified by dpkg-sig --verify.
+	RequireSignedDebs bool
+
+	// MaxJSONBodyBytes limits request bodies on all routes but /pool/.
+	// Zero uses DefaultMaxJSONBodyBytes.
+	MaxJSONBodyBytes int64
+
+	// AdvertiseTXT adds custom TXT fields to the mDNS record, e.g.
+	// "tier": "production". Fields computed by groom, such as "version",
+	// take precedence.
+	AdvertiseTXT map[string]string
+
+	// PoolScanInterval is how often PoolDir and InstalledDir are scanned to
+	// refresh the cached package metadata. Zero uses DefaultPoolScanInterval.
+	PoolScanInterval time.Duration
+
+	// Permissions of files created in PoolDir and InstalledDir. Directories
+	// get the same mode plus traverse bits. Zero uses DefaultFileMode.
+	PoolDirMode      os.FileMode
+	InstalledDirMode os.FileMode
+
+	// PoolFileNamePattern, when set, rejects uploads whose file name does
+	// not match, e.g. DebianFileNamePattern.
+	PoolFileNamePattern *regexp.Regexp
+
+	// EnableIPv6 listens on ListenAddr over IPv6 ("tcp6"), e.g. "[::]:8080".
+	// Otherwise the listener is IPv4 only ("tcp4"). BindInterface only
+	// resolves IPv4 addresses, so Start refuses to combine them.
+	EnableIPv6 bool
+
+	// MaxConcurrentRequests caps the requests served at once; extra ones
+	// get 503 Service Unavailable. Zero means unlimited.
+	MaxConcurrentRequests int
+
+	// Hooks maps lifecycle events (HookPreInstall, HookPostRemove, ...) to a
+	// command run with GROOM_EVENT, GROOM_PACKAGE and GROOM_VERSION set.
+	// Install events run from the installer script. Hook failures are only
+	// logged.
+	Hooks map[string][]string
+
+	// DisablePurge refuses DELETE /installed/, which removes every tracked
+	// package but groom itself in one call. Without it, anyone who can reach
+	// the API can wipe the managed software.
+	DisablePurge bool
+
+	// SystemdRunFlags are appended to the systemd-run invocation of the
+	// installer, e.g. "--property=MemoryMax=512M" or "--slice=groom.slice".
+	SystemdRunFlags []string
+
+	// PoolSizeQuotaPerPackage caps the number of pool versions kept per
+	// package name. Uploading past the quota evicts the oldest versions.
+	PoolSizeQuotaPerPackage map[string]int64
+
+	// StagingDir, on the same filesystem as InstalledDir, receives a copy of
+	// the package before it is renamed into InstalledDir, so the commit is
+	// atomic even when PoolDir is on another filesystem.
+	StagingDir string
+
+	// UnitNamePrefix starts installer unit names, <prefix>-install-<package>,
+	// so several agents on one host do not collide. Empty uses
+	// DefaultUnitNamePrefix.
+	UnitNamePrefix string
+
+	// InstallRateBytesPerSecond is the unpack rate GET /transaction/eta
+	// assumes. Zero uses DefaultInstallRateBytesPerSecond.
+	InstallRateBytesPerSecond int64
+
+	// EnableWebUI serves a read-only HTML status page at GET /.
+	EnableWebUI bool
 }
 
+// DebianFileNamePattern matches the Debian naming convention
+// <package>_<version>_<architecture>.deb.
+var DebianFileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.\-]+_[0-9][A-Za-z0-9.+~\-]*_[a-z0-9\-]+\.deb$`)
+
 // Server represents the daemon service agent.
 type Server struct {
-	cfg             Config
-	httpServer      *http.Server
+	cfg         Config
+	httpServer  *http.Server
+	pprofServer *http.Server
+	audit       *auditLog
+	port        int
+	hup         chan os.Signal
+	done        chan struct{}
+
+	activeUnits sync.Map // installer unit name -> *installJob, while running
+	controls    controlCache
+	drain       drainState
+
+	mu              sync.Mutex // protects stopAdvertising
 	stopAdvertising func()
 }
 
 // New creates a new Server instance with the provided configuration.
 func New(cfg Config) *Server {
+	if cfg.ReadHeaderTimeout == 0 {
+		cfg.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	if cfg.OperationTimeout == 0 {
+		cfg.OperationTimeout = DefaultOperationTimeout
+	}
+	if cfg.InstallRateBytesPerSecond == 0 {
+		cfg.InstallRateBytesPerSecond = DefaultInstallRateBytesPerSecond
+	}
+	if cfg.UnitNamePrefix == "" {
+		cfg.UnitNamePrefix = DefaultUnitNamePrefix
+	}
+	if cfg.PoolDirMode == 0 {
+		cfg.PoolDirMode = DefaultFileMode
+	}
+	if cfg.InstalledDirMode == 0 {
+		cfg.InstalledDirMode = DefaultFileMode
+	}
+	if cfg.PoolScanInterval == 0 {
+		cfg.PoolScanInterval = DefaultPoolScanInterval
+	}
+	if cfg.MaxJSONBodyBytes == 0 {
+		cfg.MaxJSONBodyBytes = DefaultMaxJSONBodyBytes
+	}
+	if cfg.PprofAddr == "" {
+		cfg.PprofAddr = DefaultPprofAddr
+	}
+	if cfg.BackupRetentionCheckInterval == 0 {
+		cfg.BackupRetentionCheckInterval = DefaultBackupRetentionCheckInterval
+	}
+	if cfg.BackupRetentionDuration == 0 {
+		cfg.BackupRetentionDuration = DefaultBackupRetentionDuration
+	}
 	return &Server{
 		cfg: cfg,
 	}
@@ -36,64 +276,299 @@ func New(cfg Config) *Server {
 // Start initializes resources and starts the background services (HTTP, mDNS).
 // It is non-blocking.
 func (s *Server) Start() {
-	log.Printf("🎩 Groom Service started on %s", s.cfg.ListenAddr)
+	slog.Info("🎩 Groom Service started", "addr", s.cfg.ListenAddr)
+
+	if name, err := selfPackage(); err != nil {
+		slog.Warn("Could not detect own package, using the configured one", "package", s.cfg.SelfPackageName, "err", err)
+	} else {
+		if name != s.cfg.SelfPackageName {
+			slog.Info("Detected own package", "package", name, "configured", s.cfg.SelfPackageName)
+		}
+		s.cfg.SelfPackageName = name
+	}
 
 	// Ensure directories exist
-	os.MkdirAll(s.cfg.PoolDir, 0755)
-	os.MkdirAll(s.cfg.InstalledDir, 0755)
+	os.MkdirAll(s.cfg.PoolDir, dirMode(s.cfg.PoolDirMode))
+	os.MkdirAll(s.cfg.InstalledDir, dirMode(s.cfg.InstalledDirMode))
+	if s.cfg.ReleaseDir != "" {
+		os.MkdirAll(s.cfg.ReleaseDir, 0755)
+	}
+	if s.cfg.StagingDir != "" {
+		os.MkdirAll(s.cfg.StagingDir, dirMode(s.cfg.InstalledDirMode))
+	}
 
-	// Extract port for mDNS
-	_, portStr, err := net.SplitHostPort(s.cfg.ListenAddr)
-	if err != nil {
-		if strings.HasPrefix(s.cfg.ListenAddr, ":") {
-			portStr = s.cfg.ListenAddr[1:]
-		} else {
-			portStr = "8080"
+	if s.cfg.ArchSubdirs {
+		if count, err := s.migratePoolOp(); err != nil {
+			slog.Error("Pool migration failed", "err", err)
+		} else if count > 0 {
+			slog.Info("📦 Moved pool files into architecture subdirectories", "count", count)
 		}
 	}
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		log.Printf("Could not parse port from '%s', using default 8080", portStr)
-		port = 8080
+
+	// Extract port for mDNS
+	port := listenPort(s.cfg.ListenAddr)
+
+	listenAddr := s.cfg.ListenAddr
+	if s.cfg.BindInterface != "" {
+		if s.cfg.EnableIPv6 {
+			slog.Error("BindInterface cannot be combined with EnableIPv6", "interface", s.cfg.BindInterface)
+			os.Exit(1)
+		}
+		ip, err := interfaceIPv4(s.cfg.BindInterface)
+		if err != nil {
+			slog.Error("Cannot bind to interface", "interface", s.cfg.BindInterface, "err", err)
+			os.Exit(1)
+		}
+		listenAddr = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		slog.Info("Binding to interface", "interface", s.cfg.BindInterface, "addr", listenAddr)
 	}
 
+	serveTCP := s.cfg.ListenAddr != "" || s.cfg.UnixSocketPath == ""
+
 	// Start mDNS advertising
-	closer, err := s.startAdvertisingOp(port)
-	if err != nil {
-		log.Printf("Failed to start mDNS advertising: %v", err)
-	} else {
-		s.stopAdvertising = closer
+	if serveTCP {
+		s.port = port
+	}
+	s.refreshAdvertising()
+
+	// Refresh the mDNS record on SIGHUP, e.g. after a hostname change.
+	s.hup = make(chan os.Signal, 1)
+	signal.Notify(s.hup, syscall.SIGHUP)
+	go func() {
+		for range s.hup {
+			slog.Info("🔄 SIGHUP received, refreshing mDNS advertising.")
+			s.refreshAdvertising()
+		}
+	}()
+
+	// Periodically clean up stale installer backups
+	s.done = make(chan struct{})
+	go s.backupRetentionLoop()
+	go s.poolScanLoop()
+
+	if s.cfg.AuditLogFile != "" {
+		audit, err := openAuditLog(s.cfg.AuditLogFile, s.cfg.AuditLogMaxSizeMB, s.cfg.AuditLogMaxBackups)
+		if err != nil {
+			slog.Error("Cannot open audit log", "err", err)
+			os.Exit(1)
+		}
+		s.audit = audit
 	}
 
 	// Setup HTTP Server
 	mux := http.NewServeMux()
 	s.registerHandlers(mux)
+	handler := chain(mux,
+		CORSMiddleware(s.cfg.AllowedOrigins),
+		s.concurrencyMiddleware(s.cfg.MaxConcurrentRequests),
+		auditMiddleware(s.audit),
+		s.drainMiddleware,
+		s.maxBodyMiddleware(s.cfg.MaxJSONBodyBytes),
+	)
 
 	s.httpServer = &http.Server{
-		Addr:    s.cfg.ListenAddr,
-		Handler: mux,
+		Addr:              listenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
 	}
 
 	// Start HTTP Server in a goroutine
+	if serveTCP {
+		addr := listenAddr
+		if addr == "" {
+			addr = ":http"
+		}
+		ln, err := s.listenTCP(addr)
+		if err != nil {
+			slog.Error("Cannot listen", "addr", addr, "err", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Error("Server error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if s.cfg.UnixSocketPath != "" {
+		ln, err := listenUnix(s.cfg.UnixSocketPath)
+		if err != nil {
+			slog.Error("Cannot listen on unix socket", "path", s.cfg.UnixSocketPath, "err", err)
+			os.Exit(1)
+		}
+		slog.Info("🔌 Listening on unix socket", "path", s.cfg.UnixSocketPath)
+		go func() {
+			if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Error("Server error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if s.cfg.EnablePprof {
+		s.startPprof()
+	}
+}
+
+// startPprof serves the profiling endpoints on their own internal listener.
+func (s *Server) startPprof() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	s.pprofServer = &http.Server{Addr: s.cfg.PprofAddr, Handler: mux}
+	slog.Info("🔬 pprof enabled", "addr", s.cfg.PprofAddr)
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		if err := s.pprofServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("pprof server error", "err", err)
 		}
 	}()
 }
 
 // Stop gracefully shuts down the server and its background processes.
 func (s *Server) Stop(ctx context.Context) {
-	log.Println("👋 Shutdown signal received.")
+	slog.Info("👋 Shutdown signal received.")
 
+	if s.done != nil {
+		close(s.done)
+	}
+
+	if s.hup != nil {
+		signal.Stop(s.hup)
+		close(s.hup)
+	}
+
+	s.mu.Lock()
 	if s.stopAdvertising != nil {
 		s.stopAdvertising()
+		s.stopAdvertising = nil
 	}
+	s.mu.Unlock()
 
 	if s.httpServer != nil {
 		if err := s.httpServer.Shutdown(ctx); err != nil {
-			log.Printf("HTTP shutdown error: %v", err)
+			slog.Error("HTTP shutdown error", "err", err)
 		}
 	}
-	log.Println("🛑 Groom stopped.")
+	if s.pprofServer != nil {
+		if err := s.pprofServer.Shutdown(ctx); err != nil {
+			slog.Error("pprof shutdown error", "err", err)
+		}
+	}
+	if s.audit != nil {
+		s.audit.Close()
+	}
+	slog.Info("🛑 Groom stopped.")
+}
+
+// refreshAdvertising (re)starts mDNS advertising, replacing any previous record.
+// Nothing is advertised when the server has no TCP port.
+func (s *Server) refreshAdvertising() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopAdvertising != nil {
+		s.stopAdvertising()
+		s.stopAdvertising = nil
+	}
+	if s.port == 0 {
+		return
+	}
+	closer, err := s.startAdvertisingOp(s.port)
+	if err != nil {
+		slog.Error("Failed to start mDNS advertising", "err", err)
+		return
+	}
+	s.stopAdvertising = closer
+}
+
+// backupRetentionLoop deletes stale ".previous" backups until the server stops.
+func (s *Server) backupRetentionLoop() {
+	ticker := time.NewTicker(s.cfg.BackupRetentionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			count, err := s.cleanupBackupsOp(s.cfg.BackupRetentionDuration)
+			if err != nil {
+				slog.Error("Backup cleanup failed", "err", err)
+			} else if count > 0 {
+				slog.Info("🧹 Removed stale backups", "count", count)
+			}
+		}
+	}
+}
+
+// listenPort returns the port of a ListenAddr such as ":8080", "0.0.0.0:8080"
+// or "[::]:8080". It falls back to 8080 when addr has no usable port.
+func listenPort(addr string) int {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		if strings.HasPrefix(addr, ":") {
+			portStr = addr[1:]
+		} else {
+			portStr = "8080"
+		}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		slog.Warn("Could not parse port, using default 8080", "port", portStr)
+		return 8080
+	}
+	return port
+}
+
+// listenTCP listens on addr over IPv6 when EnableIPv6 is set and over IPv4
+// otherwise, rather than relying on the OS default for "tcp".
+func (s *Server) listenTCP(addr string) (net.Listener, error) {
+	network := "tcp4"
+	if s.cfg.EnableIPv6 {
+		network = "tcp6"
+	}
+	return net.Listen(network, addr)
+}
+
+// interfaceIPv4 returns the first IPv4 address assigned to the named interface.
+func interfaceIPv4(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address on interface %s", name)
+}
+
+// listenUnix listens on a Unix domain socket at path, replacing any stale
+// socket file left by a previous run.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
 }
@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenPort(t *testing.T) {
+	tests := []struct {
+		addr string
+		want int
+	}{
+		{":8080", 8080},
+		{"0.0.0.0:9090", 9090},
+		{"[::]:8080", 8080},
+		{"[::1]:9000", 9000},
+		{"[fe80::1%eth0]:7000", 7000},
+		{"", 8080},
+		{"::1", 8080},   // unbracketed IPv6 has no port
+		{"[::1]", 8080}, // brackets without a port
+		{"localhost:http", 8080},
+	}
+	for _, tt := range tests {
+		if got := listenPort(tt.addr); got != tt.want {
+			t.Errorf("listenPort(%q) = %d, want %d", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// skipWithoutIPv6 skips tests on hosts without an IPv6 loopback.
+func skipWithoutIPv6(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenTCPIPv6(t *testing.T) {
+	skipWithoutIPv6(t)
+	s := New(Config{EnableIPv6: true})
+	ln, err := s.listenTCP("[::1]:0")
+	if err != nil {
+		t.Fatalf("listenTCP([::1]:0): %v", err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	if addr.IP.To4() != nil || !addr.IP.IsLoopback() {
+		t.Errorf("listening on %v, want the IPv6 loopback", addr)
+	}
+	if port := listenPort(ln.Addr().String()); port != addr.Port {
+		t.Errorf("listenPort(%q) = %d, want %d", ln.Addr(), port, addr.Port)
+	}
+
+	conn, err := net.Dial("tcp6", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial %v: %v", ln.Addr(), err)
+	}
+	conn.Close()
+}
+
+func TestListenTCPIPv4Only(t *testing.T) {
+	s := New(Config{})
+	if ln, err := s.listenTCP("[::1]:0"); err == nil {
+		ln.Close()
+		t.Fatal("listenTCP([::1]:0) succeeded without EnableIPv6, want an error")
+	}
+
+	ln, err := s.listenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listenTCP(127.0.0.1:0): %v", err)
+	}
+	defer ln.Close()
+	if ip := ln.Addr().(*net.TCPAddr).IP; ip.To4() == nil {
+		t.Errorf("listening on %v, want IPv4", ip)
+	}
+}
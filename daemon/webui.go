@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// webUIPage is the status page served at GET / when EnableWebUI is set. It
+// polls the JSON API from the browser, so it needs no server-side rendering.
+//
+//go:embed webui.html
+var webUIPage []byte
+
+func (s *Server) handleWebUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		s.writeError(w, newError(http.StatusNotFound, CodeNotFound, "Not found"))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(webUIPage)
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/grandcat/zeroconf"
@@ -13,17 +14,35 @@ import (
 var CurrentVersion = "v0.0.1"
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel()})))
+
 	server, err := zeroconf.Register("groom-service", "_groom._tcp", "local.", 8080, nil, nil)
 	if err != nil {
-		log.Fatalf("Failed to register mDNS service: %v", err)
+		slog.Error("Failed to register mDNS service", "err", err)
+		os.Exit(1)
 	}
 	defer server.Shutdown()
 
-	log.Println("mDNS responder started. Press Ctrl+C to exit.")
+	slog.Info("mDNS responder started. Press Ctrl+C to exit.")
 	// Signal Handling
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 
-	log.Println("Shutting down.")
+	slog.Info("Shutting down.")
+}
+
+// logLevel reads the verbosity from GROOM_LOG_LEVEL (DEBUG, INFO, WARN, ERROR).
+// It defaults to INFO when unset or unknown.
+func logLevel() slog.Level {
+	switch strings.ToUpper(os.Getenv("GROOM_LOG_LEVEL")) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "WARN":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
 }